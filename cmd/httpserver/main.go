@@ -1,26 +1,42 @@
 package main
 
 import (
-	"crypto/sha256"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
+	"time"
 
 	"github.com/OmarJarbou/httpfromtcp/internal/request"
 	"github.com/OmarJarbou/httpfromtcp/internal/response"
 	"github.com/OmarJarbou/httpfromtcp/internal/server"
+	"github.com/OmarJarbou/httpfromtcp/internal/websocket"
 )
 
 const port = 42069
 
 func main() {
-	server, err := server.Serve(port, videoHandler)
+	access_log := server.NewAccessLogger(os.Stdout, server.COMBINED_LOG_FORMAT)
+	server_metrics := server.NewMetrics()
+	router := server.NewRouter()
+	router.Handle("GET", "/", handler)
+	router.Handle("GET", "/video", videoHandler)
+	httpbin_proxy, err := server.NewReverseProxy("https://httpbin.org")
+	if err != nil {
+		log.Fatalf("Error creating proxy: %v", err)
+	}
+	defer httpbin_proxy.Close()
+	httpbin_proxy.StripPrefix = "/httpbin"
+	for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		// "/httpbin/" only matches what is below it, not "/httpbin" itself
+		router.Handle(method, "/httpbin", httpbin_proxy.ServeRequest)
+		router.Handle(method, "/httpbin/", httpbin_proxy.ServeRequest)
+	}
+	router.Handle("GET", "/metrics", server.MetricsHandler(server_metrics))
+	router.Handle("GET", "/live", liveHandler)
+	server, err := server.Serve(port, router.ServeRequest, server.WithAccessLog(access_log), server.WithMetrics(server_metrics))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -38,7 +54,7 @@ func main() {
 	// the server.
 }
 
-func handler(w response.Writer, r *request.Request) {
+func handler(w *response.Writer, r *request.Request) {
 	handler_response := server.HandlerResponse{}
 	switch r.RequestLine.RequestTarget {
 	case "/yourproblem":
@@ -58,128 +74,52 @@ func handler(w response.Writer, r *request.Request) {
 	handler_response.HandlerResponseWriter(w)
 }
 
-func videoHandler(w response.Writer, r *request.Request) {
-	handler_response := server.HandlerResponse{}
-	if r.RequestLine.RequestTarget == "/video" {
-		handler_response.StatusCode = response.OK
-
-		handler_response.SetHeader("Content-Type", "video/mp4")
-		handler_response.SetHeader("Connection", "close")
-
-		err := w.WriteStatusLine(handler_response.StatusCode)
-		if err != nil {
-			log.Println("Error while writing status line: " + err.Error())
-			w.Close()
-			return
-		}
-		err = w.WriteHeaders(handler_response.GetHeaders())
-		if err != nil {
-			log.Println("Error while writing headers: " + err.Error())
-			w.Close()
-			return
-		}
-
-		video_data, err := os.ReadFile("assets/vim.mp4")
-		if err != nil {
-			log.Println("Error while reading video file: " + err.Error())
-			w.Close()
-			return
-		}
-		_, err = w.WriteBody(video_data)
-		if err != nil {
-			log.Println("Error while writing body: " + err.Error())
-			w.Close()
-			return
-		}
+func videoHandler(w *response.Writer, r *request.Request) {
+	video_file, err := os.Open("assets/vim.mp4")
+	if err != nil {
+		log.Println("Error while opening video file: " + err.Error())
+		w.WriteHeader(response.SERVER_ERROR)
+		return
+	}
+	defer video_file.Close()
+	w.Header().Set("Content-Type", "video/mp4")
+	_, err = w.ReadFrom(video_file)
+	if err != nil {
+		log.Println("Error while writing body: " + err.Error())
+		w.Close()
+		return
 	}
 }
 
-func proxyHandler(w response.Writer, r *request.Request) {
-	handler_response := server.HandlerResponse{}
-	if strings.HasPrefix(r.RequestLine.RequestTarget, "/httpbin") {
-		client := &http.Client{}
-		url := "https://httpbin.org" + strings.TrimPrefix(r.RequestLine.RequestTarget, "/httpbin")
-		req, err := client.Get(url)
-		if err != nil {
-			handler_response.HandlerErrorResponse(w, response.CLIENT_ERROR, "Error while making request to \""+url+"\": "+err.Error())
-			return
-		} else {
-			status, err := strconv.Atoi(strings.Split(req.Status, " ")[0])
-			if err != nil {
-				handler_response.HandlerErrorResponse(w, response.SERVER_ERROR, "Error while parsing status of response from \""+url+"\": "+err.Error())
-				return
-			}
-
-			if status >= 200 && status <= 299 {
-				handler_response.StatusCode = response.OK
-			} else if status >= 400 && status <= 499 {
-				handler_response.StatusCode = response.CLIENT_ERROR
-			} else if status >= 500 && status <= 599 {
-				handler_response.StatusCode = response.SERVER_ERROR
-			} else {
-				handler_response.StatusCode = response.OK
-			}
-
-			handler_response.SetHeader("Content-Type", "text/plain")
-			handler_response.SetHeader("Connection", "close")
-			handler_response.SetHeader("Transfer-Encoding", "chunked")
-			handler_response.SetHeader("Trailer", "X-Content-SHA256, X-Content-Length")
-
-			err = w.WriteStatusLine(handler_response.StatusCode)
-			if err != nil {
-				log.Println("Error while writing status line: " + err.Error())
-				w.Close()
-				return
-			}
-			err = w.WriteHeaders(handler_response.GetHeaders())
-			if err != nil {
-				log.Println("Error while writing headers: " + err.Error())
-				w.Close()
-				return
-			}
-
-			hasher := sha256.New()
-			body := make([]byte, 1024)
-			body_bytes := 0
-			for {
-				n, body_read_err := req.Body.Read(body)
-				body_bytes += n
-				fmt.Println(n)
-				if body_read_err != nil && !(body_read_err == io.EOF && n > 0) {
-					if body_read_err == io.EOF {
-						break
-					}
-					log.Println("Error while parsing body of response from \"" + url + "\": " + body_read_err.Error())
-					w.Close()
-					return
-				}
-
-				hasher.Write(body[:n])
-				_, err = w.WriteChunkedBody(body[:n])
-				if err != nil {
-					log.Println("Error while writing a body chunk: " + err.Error())
-					w.Close()
-					return
-				}
-
-				if body_read_err == io.EOF {
-					break
-				}
-			}
-			_, err = w.WriteChunkedBodyDone()
-			if err != nil {
-				log.Println("Error while writing body done chunk: " + err.Error())
-				w.Close()
+// liveHandler pushes the server time over a WebSocket once a second until the
+// client goes away.
+func liveHandler(w *response.Writer, r *request.Request) {
+	upgrader := websocket.Upgrader{EnableCompression: true}
+	conn, err := upgrader.Upgrade(w, r)
+	if err != nil {
+		log.Println("Error while upgrading to websocket: " + err.Error())
+		return
+	}
+	// the connection is ours now; closing it also ends the reader below
+	defer conn.NetConn().Close()
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
 				return
 			}
-
-			hash := hasher.Sum(nil)
-			handler_response.SetHeader("X-Content-SHA256", fmt.Sprintf("%x", hash))
-			handler_response.SetHeader("X-Content-Length", strconv.Itoa(body_bytes))
-			err = w.WriteTrailers(handler_response.GetHeaders())
+		}
+	}()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case now := <-ticker.C:
+			err = conn.WriteMessage(websocket.TEXT_MESSAGE, []byte(now.Format(time.RFC3339)))
 			if err != nil {
-				log.Println("Error while writing trailers: " + err.Error())
-				w.Close()
 				return
 			}
 		}
@@ -0,0 +1,358 @@
+// Package client is the client side of this HTTP/1.1 implementation: it
+// sends requests over pooled keep-alive connections and reads the responses.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+const (
+	DEFAULT_DIAL_TIMEOUT            = 30 * time.Second
+	DEFAULT_IDLE_TIMEOUT            = 90 * time.Second
+	DEFAULT_MAX_IDLE_CONNS_PER_HOST = 2
+	DEFAULT_MAX_REDIRECTS           = 10
+	// REDIRECT_DRAIN_LIMIT is how much of a redirect response body is read
+	// to keep its connection; longer bodies close the connection instead.
+	REDIRECT_DRAIN_LIMIT = 4096
+)
+
+// idempotentMethods are the methods whose requests can be sent again when a
+// connection fails (RFC 9110 section 9.2.2). They are fixed here rather than
+// looked up in the server's method registry, which RegisterMethod changes.
+var idempotentMethods = map[string]struct{}{
+	"GET":     {},
+	"HEAD":    {},
+	"OPTIONS": {},
+	"TRACE":   {},
+	"PUT":     {},
+	"DELETE":  {},
+}
+
+type Client struct {
+	// Timeout bounds a whole exchange, from dialing to the end of the
+	// response body; 0 for none.
+	Timeout     time.Duration
+	DialTimeout time.Duration
+	// IdleTimeout is how long a kept-alive connection may wait in the pool
+	// before it is not used anymore; 0 for no limit.
+	IdleTimeout         time.Duration
+	MaxIdleConnsPerHost int
+	// MaxRedirects is how many redirects Do follows before it gives up; with
+	// 0 redirect responses are returned as they are.
+	MaxRedirects int
+	// TLSConfig is used for https URLs; the server name is filled in.
+	TLSConfig *tls.Config
+
+	mutex sync.Mutex
+	idle  map[string][]*conn
+}
+
+func NewClient() *Client {
+	return &Client{
+		DialTimeout:         DEFAULT_DIAL_TIMEOUT,
+		IdleTimeout:         DEFAULT_IDLE_TIMEOUT,
+		MaxIdleConnsPerHost: DEFAULT_MAX_IDLE_CONNS_PER_HOST,
+		MaxRedirects:        DEFAULT_MAX_REDIRECTS,
+		idle:                map[string][]*conn{},
+	}
+}
+
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers headers.Headers
+	Body    []byte
+
+	ctx context.Context
+}
+
+// NewRequest returns a request for raw_url, which must be an absolute http or
+// https URL. ctx cancels the whole exchange, including reading the body.
+func NewRequest(ctx context.Context, method, raw_url string, body []byte) (*Request, error) {
+	if !headers.IsToken(method) {
+		return nil, errors.New("\"" + method + "\": method must be a token")
+	}
+	parsed, err := url.Parse(raw_url)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, errors.New("\"" + raw_url + "\": URL must be an absolute http or https URL")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Request{
+		Method:  method,
+		URL:     parsed,
+		Headers: headers.Headers{},
+		Body:    body,
+		ctx:     ctx,
+	}, nil
+}
+
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// address is the host:port to connect to for the request.
+func (r *Request) address() string {
+	port := r.URL.Port()
+	if port == "" {
+		port = "80"
+		if r.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(r.URL.Hostname(), port)
+}
+
+func (c *Client) Get(ctx context.Context, raw_url string) (*Response, error) {
+	req, err := NewRequest(ctx, "GET", raw_url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req and returns the response once its head arrived, following
+// redirects up to MaxRedirects. The caller must close the response Body.
+func (c *Client) Do(req *Request) (*Response, error) {
+	resp, err := c.roundTrip(req)
+	for redirects := 0; err == nil && c.MaxRedirects > 0 && isRedirect(resp.StatusCode); redirects++ {
+		location, ok := resp.Get("Location")
+		if !ok {
+			return resp, nil
+		}
+		next_url, parse_err := req.URL.Parse(location)
+		drainBody(resp.Body)
+		if parse_err != nil {
+			return nil, errors.New("redirect to an invalid location \"" + location + "\": " + parse_err.Error())
+		}
+		if next_url.Scheme != "http" && next_url.Scheme != "https" {
+			return nil, errors.New("redirect to an unsupported location: " + location)
+		}
+		if redirects+1 > c.MaxRedirects {
+			return nil, errors.New("stopped after " + strconv.Itoa(c.MaxRedirects) + " redirects")
+		}
+		req = redirectRequest(req, resp.StatusCode, next_url)
+		resp, err = c.roundTrip(req)
+	}
+	return resp, err
+}
+
+func isRedirect(status_code response.StatusCode) bool {
+	switch status_code {
+	case response.MOVED_PERMANENTLY, response.FOUND, response.SEE_OTHER, response.TEMPORARY_REDIRECT, response.PERMANENT_REDIRECT:
+		return true
+	}
+	return false
+}
+
+// redirectRequest builds the request that follows a redirect: 303 (and, as
+// browsers do, 301/302 after a POST) turn into a body-less GET, 307 and 308
+// repeat the request as it was. Credentials stay with the original host.
+func redirectRequest(req *Request, status_code response.StatusCode, next_url *url.URL) *Request {
+	next := &Request{
+		Method:  req.Method,
+		URL:     next_url,
+		Headers: headers.Headers{},
+		Body:    req.Body,
+		ctx:     req.ctx,
+	}
+	for key, value := range req.Headers {
+		next.Headers[key] = value
+	}
+	if (status_code == response.SEE_OTHER && req.Method != "HEAD") ||
+		((status_code == response.MOVED_PERMANENTLY || status_code == response.FOUND) && req.Method == "POST") {
+		next.Method = "GET"
+		next.Body = nil
+		next.Headers.Delete("Content-Type")
+	}
+	if next_url.Host != req.URL.Host {
+		next.Headers.Delete("Authorization")
+		next.Headers.Delete("Cookie")
+	}
+	next.Headers.Delete("Host")
+	return next
+}
+
+// drainBody reads a little of what is left of body so that its connection
+// can be reused, and closes it.
+func drainBody(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, REDIRECT_DRAIN_LIMIT))
+	body.Close()
+}
+
+// roundTrip does a single exchange. A request that failed on a pooled
+// connection before any response byte arrived is retried once on a new
+// connection if it is idempotent, since the server may have closed the idle
+// connection in the meantime.
+func (c *Client) roundTrip(req *Request) (*Response, error) {
+	ctx := req.Context()
+	cancel := context.CancelFunc(func() {})
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+	}
+	for attempt := 0; ; attempt++ {
+		pooled, reused, err := c.getConn(ctx, req.URL.Scheme, req.address())
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		// cancelling ctx interrupts whatever the connection is doing
+		stop_watching := context.AfterFunc(ctx, func() {
+			pooled.net_conn.SetDeadline(time.Unix(1, 0))
+		})
+		bytes_read_before := pooled.counter.bytes_read
+		resp, err := c.exchange(pooled, req)
+		if err != nil {
+			stop_watching()
+			pooled.close()
+			if ctx.Err() != nil {
+				cancel()
+				return nil, ctx.Err()
+			}
+			_, idempotent := idempotentMethods[req.Method]
+			if reused && attempt == 0 && pooled.counter.bytes_read == bytes_read_before && idempotent {
+				continue
+			}
+			cancel()
+			return nil, err
+		}
+
+		keep_alive := reusable(req, resp)
+		release := func(body_complete bool) {
+			interrupted := !stop_watching()
+			cancel()
+			if body_complete && keep_alive && !interrupted {
+				c.putConn(req.URL.Scheme, req.address(), pooled)
+			} else {
+				pooled.close()
+			}
+		}
+		resp.Request = req
+		if resp.ContentLength == 0 && !resp.CloseDelimited() {
+			release(true)
+			return resp, nil
+		}
+		resp.Body = &body{reader: resp.Body, release: release}
+		return resp, nil
+	}
+}
+
+// exchange writes req to pooled and reads the final response head, skipping
+// interim 1xx responses.
+func (c *Client) exchange(pooled *conn, req *Request) (*Response, error) {
+	err := writeRequest(pooled.writer, req)
+	if err == nil {
+		err = pooled.writer.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	for {
+		resp, err := response.ResponseFromReader(pooled.reader, req.Method)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != response.SWITCHING_PROTOCOLS {
+			continue
+		}
+		return &Response{Response: resp}, nil
+	}
+}
+
+// reusable reports whether the connection can carry another exchange once
+// the body of resp was read.
+func reusable(req *Request, resp *Response) bool {
+	if resp.CloseDelimited() || resp.HttpVersion != "1.1" || resp.StatusCode == response.SWITCHING_PROTOCOLS {
+		return false
+	}
+	return !hasToken(resp.Headers, "Connection", "close") && !hasToken(req.Headers, "Connection", "close")
+}
+
+func hasToken(h headers.Headers, name, token string) bool {
+	value, _ := h.Get(name)
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRequest serializes req in origin-form. The body is always framed with
+// a Content-Length, whatever framing headers the caller set.
+func writeRequest(writer io.Writer, req *Request) error {
+	outgoing := request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "1.1",
+			RequestTarget: req.URL.RequestURI(),
+			Method:        req.Method,
+		},
+		Headers: headers.Headers{},
+		Body:    req.Body,
+	}
+	for key, value := range req.Headers {
+		outgoing.Headers.Set(key, value)
+	}
+	outgoing.Headers.Delete("Transfer-Encoding")
+	outgoing.Headers.Delete("Content-Length")
+	if _, ok := outgoing.Get("Host"); !ok {
+		outgoing.Headers.Set("Host", req.URL.Host)
+	}
+	if req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+		outgoing.Headers.Set("Content-Length", "0") // Write replaces it with the body length
+	}
+	return outgoing.Write(writer)
+}
+
+// body hands the connection back once the response body was read to the end,
+// or closes it if the body is closed early or fails.
+type body struct {
+	reader  io.ReadCloser
+	release func(body_complete bool)
+	err     error // what Read returns once the connection was released
+	mutex   sync.Mutex
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.reader.Read(p)
+	if err != nil {
+		b.err = err
+		b.release(err == io.EOF)
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.err == nil {
+		b.err = errors.New("read on a closed response body")
+		b.release(false)
+	}
+	return nil
+}
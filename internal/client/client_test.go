@@ -0,0 +1,334 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer reads requests off its connections and answers each with what
+// reply returns for it. A connection is closed after a reply that contains
+// "connection: close", or when reply returns "".
+type testServer struct {
+	url         string
+	connections atomic.Int32
+}
+
+func startTestServer(t *testing.T, reply func(request string) string) *testServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	server := &testServer{url: "http://" + listener.Addr().String()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.connections.Add(1)
+			go serveTestConn(conn, reply)
+		}
+	}()
+	return server
+}
+
+func serveTestConn(conn net.Conn, reply func(request string) string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		request := ""
+		content_length := 0
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			request += line
+			if value, found := strings.CutPrefix(strings.ToLower(line), "content-length: "); found {
+				content_length, _ = strconv.Atoi(strings.TrimSpace(value))
+			}
+			if line == "\r\n" {
+				break
+			}
+		}
+		body := make([]byte, content_length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return
+		}
+		answer := reply(request + string(body))
+		if answer == "" {
+			return
+		}
+		conn.Write([]byte(answer))
+		if strings.Contains(strings.ToLower(answer), "connection: close") {
+			return
+		}
+	}
+}
+
+func readBody(t *testing.T, resp *Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return string(body)
+}
+
+func TestBodyFraming(t *testing.T) {
+	server := startTestServer(t, func(request string) string {
+		switch {
+		case strings.HasPrefix(request, "GET /length "):
+			return "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+		case strings.HasPrefix(request, "GET /chunked "):
+			return "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+				"6;ext=1\r\nhello \r\n5\r\nworld\r\n0\r\nX-Checksum: abc\r\n\r\n"
+		case strings.HasPrefix(request, "HEAD /length "):
+			return "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"
+		case strings.HasPrefix(request, "GET /empty "):
+			return "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n"
+		case strings.HasPrefix(request, "GET /until-close "):
+			return "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nall of it"
+		}
+		return "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"
+	})
+	client := NewClient()
+
+	// Test: Content-Length body
+	resp, err := client.Get(context.Background(), server.url+"/length")
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, resp.StatusCode)
+	assert.Equal(t, "OK", resp.Status)
+	assert.Equal(t, int64(5), resp.ContentLength)
+	assert.Equal(t, "hello", readBody(t, resp))
+
+	// Test: Chunked body with extensions and trailers
+	resp, err = client.Get(context.Background(), server.url+"/chunked")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, "hello world", readBody(t, resp))
+	assert.Equal(t, "abc", resp.Trailers["x-checksum"])
+
+	// Test: Response to HEAD has no body
+	req, err := NewRequest(context.Background(), "HEAD", server.url+"/length", nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "5", resp.Headers["content-length"])
+	assert.Equal(t, "", readBody(t, resp))
+
+	// Test: Interim response is skipped, 204 has no body
+	resp, err = client.Get(context.Background(), server.url+"/empty")
+	require.NoError(t, err)
+	assert.Equal(t, response.NO_CONTENT, resp.StatusCode)
+	assert.Equal(t, "", readBody(t, resp))
+
+	// Test: All of that went over one kept-alive connection
+	assert.Equal(t, int32(1), server.connections.Load())
+
+	// Test: Body delimited by the end of the connection
+	resp, err = client.Get(context.Background(), server.url+"/until-close")
+	require.NoError(t, err)
+	assert.Equal(t, "all of it", readBody(t, resp))
+	resp, err = client.Get(context.Background(), server.url+"/length")
+	require.NoError(t, err)
+	readBody(t, resp)
+	assert.Equal(t, int32(2), server.connections.Load())
+}
+
+func TestMalformedResponses(t *testing.T) {
+	replies := map[string]string{
+		"/status":    "HTTP/1.1 2000 OK\r\n\r\n",
+		"/version":   "HTTP/2 200 OK\r\n\r\n",
+		"/header":    "HTTP/1.1 200 OK\r\nBroken header\r\n\r\n",
+		"/length":    "HTTP/1.1 200 OK\r\nContent-Length: +5\r\n\r\nhello",
+		"/coding":    "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\n\r\n",
+		"/truncated": "HTTP/1.1 200 OK\r\nContent-Length: 10\r\nConnection: close\r\n\r\nhello",
+		"/chunk":     "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\nzz\r\nhello\r\n",
+	}
+	server := startTestServer(t, func(request string) string {
+		return replies[strings.Split(request, " ")[1]]
+	})
+	client := NewClient()
+
+	for target := range replies {
+		resp, err := client.Get(context.Background(), server.url+target)
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		assert.Error(t, err, target)
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	answered := atomic.Int32{}
+	server := startTestServer(t, func(request string) string {
+		// every connection answers one request and then goes away without
+		// saying so, like a server whose idle timeout passed
+		if answered.Add(1)%2 == 0 {
+			return ""
+		}
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+	client := NewClient()
+
+	// Test: Stale pooled connection is replaced for an idempotent request
+	resp, err := client.Get(context.Background(), server.url+"/")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readBody(t, resp))
+	time.Sleep(20 * time.Millisecond)
+	resp, err = client.Get(context.Background(), server.url+"/")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readBody(t, resp))
+	assert.Equal(t, int32(2), server.connections.Load())
+
+	// Test: Extension methods are not retried, even if the server registry
+	// calls them idempotent
+	require.NoError(t, request.RegisterMethod("PURGE", false, true))
+	answered.Store(0)
+	client = NewClient()
+	for i := 0; i < 2; i++ {
+		purge, err := NewRequest(context.Background(), "PURGE", server.url+"/", nil)
+		require.NoError(t, err)
+		resp, err = client.Do(purge)
+		if i == 0 {
+			require.NoError(t, err)
+			readBody(t, resp)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+
+	// Test: Idle connections past IdleTimeout are not used
+	client = NewClient()
+	client.IdleTimeout = time.Nanosecond
+	server = startTestServer(t, func(request string) string {
+		return "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	})
+	for i := 0; i < 2; i++ {
+		resp, err = client.Get(context.Background(), server.url+"/")
+		require.NoError(t, err)
+		readBody(t, resp)
+	}
+	assert.Equal(t, int32(2), server.connections.Load())
+}
+
+func TestRequestSerialization(t *testing.T) {
+	received := make(chan string, 1)
+	server := startTestServer(t, func(request string) string {
+		received <- request
+		return "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+	})
+	client := NewClient()
+
+	// Test: Origin-form target, Host, headers and a Content-Length body
+	req, err := NewRequest(context.Background(), "POST", server.url+"/items?x=1", []byte("hello"))
+	require.NoError(t, err)
+	req.Headers.Set("Content-Type", "text/plain")
+	req.Headers.Set("Transfer-Encoding", "chunked") // framing is up to the client
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	readBody(t, resp)
+	host := strings.TrimPrefix(server.url, "http://")
+	assert.Equal(t, "POST /items?x=1 HTTP/1.1\r\nhost: "+host+"\r\ncontent-type: text/plain\r\ncontent-length: 5\r\n\r\nhello", <-received)
+}
+
+func TestRedirects(t *testing.T) {
+	server := startTestServer(t, func(request string) string {
+		method := strings.Split(request, " ")[0]
+		switch strings.Split(request, " ")[1] {
+		case "/moved":
+			return "HTTP/1.1 302 Found\r\nLocation: /target\r\nContent-Length: 3\r\n\r\nbye"
+		case "/see-other":
+			return "HTTP/1.1 303 See Other\r\nLocation: /target\r\nContent-Length: 0\r\n\r\n"
+		case "/temporary":
+			return "HTTP/1.1 307 Temporary Redirect\r\nLocation: /target\r\nContent-Length: 0\r\n\r\n"
+		case "/loop":
+			return "HTTP/1.1 302 Found\r\nLocation: /loop\r\nContent-Length: 0\r\n\r\n"
+		case "/target":
+			body_length := len(request) - strings.Index(request, "\r\n\r\n") - 4
+			body := method + " " + strconv.Itoa(body_length)
+			return "HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+		}
+		return "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"
+	})
+	client := NewClient()
+
+	// Test: GET follows a 302
+	resp, err := client.Get(context.Background(), server.url+"/moved")
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, resp.StatusCode)
+	assert.Equal(t, "/target", resp.Request.URL.Path)
+	assert.Equal(t, "GET 0", readBody(t, resp))
+
+	// Test: POST turns into GET without a body after 303
+	req, err := NewRequest(context.Background(), "POST", server.url+"/see-other", []byte("data"))
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "GET 0", readBody(t, resp))
+
+	// Test: POST is repeated with its body after 307
+	req, err = NewRequest(context.Background(), "POST", server.url+"/temporary", []byte("data"))
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "POST 4", readBody(t, resp))
+
+	// Test: Redirect loop
+	_, err = client.Get(context.Background(), server.url+"/loop")
+	assert.ErrorContains(t, err, "stopped after 10 redirects")
+
+	// Test: Redirects are not followed with MaxRedirects 0
+	client.MaxRedirects = 0
+	resp, err = client.Get(context.Background(), server.url+"/moved")
+	require.NoError(t, err)
+	assert.Equal(t, response.FOUND, resp.StatusCode)
+	assert.Equal(t, "bye", readBody(t, resp))
+}
+
+func TestTimeouts(t *testing.T) {
+	server := startTestServer(t, func(request string) string {
+		if strings.HasPrefix(request, "GET /slow-body ") {
+			return "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\npartial"
+		}
+		time.Sleep(time.Second)
+		return "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+	})
+
+	// Test: Timeout while waiting for the response
+	client := NewClient()
+	client.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := client.Get(context.Background(), server.url+"/slow")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// Test: Timeout covers reading the body
+	resp, err := client.Get(context.Background(), server.url+"/slow-body")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err)
+
+	// Test: Cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	client = NewClient()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = client.Get(ctx, server.url+"/slow")
+	assert.ErrorIs(t, err, context.Canceled)
+}
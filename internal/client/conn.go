@@ -0,0 +1,117 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// conn is a connection to a server that can carry one exchange at a time and
+// goes back to the pool of its Client between exchanges.
+type conn struct {
+	net_conn   net.Conn
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+	counter    *readCounter
+	idle_since time.Time
+}
+
+type readCounter struct {
+	conn       net.Conn
+	bytes_read int64
+}
+
+func (rc *readCounter) Read(p []byte) (int, error) {
+	n, err := rc.conn.Read(p)
+	rc.bytes_read += int64(n)
+	return n, err
+}
+
+func newConn(net_conn net.Conn) *conn {
+	counter := &readCounter{conn: net_conn}
+	return &conn{
+		net_conn: net_conn,
+		reader:   bufio.NewReader(counter),
+		writer:   bufio.NewWriter(net_conn),
+		counter:  counter,
+	}
+}
+
+func (c *conn) close() {
+	c.net_conn.Close()
+}
+
+// connKey identifies the server a connection goes to, e.g.
+// "https://example.com:443".
+func connKey(scheme, address string) string {
+	return scheme + "://" + address
+}
+
+// getConn returns an idle connection to address if there is one that did not
+// wait longer than IdleTimeout, or dials a new one. reused tells which.
+func (c *Client) getConn(ctx context.Context, scheme, address string) (pooled *conn, reused bool, err error) {
+	key := connKey(scheme, address)
+	c.mutex.Lock()
+	for len(c.idle[key]) > 0 {
+		last := len(c.idle[key]) - 1
+		pooled = c.idle[key][last]
+		c.idle[key] = c.idle[key][:last]
+		if c.IdleTimeout <= 0 || time.Since(pooled.idle_since) < c.IdleTimeout {
+			c.mutex.Unlock()
+			return pooled, true, nil
+		}
+		pooled.close()
+	}
+	c.mutex.Unlock()
+
+	dialer := net.Dialer{Timeout: c.DialTimeout}
+	net_conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, false, err
+	}
+	if scheme == "https" {
+		config := &tls.Config{}
+		if c.TLSConfig != nil {
+			config = c.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		tls_conn := tls.Client(net_conn, config)
+		err = tls_conn.HandshakeContext(ctx)
+		if err != nil {
+			net_conn.Close()
+			return nil, false, err
+		}
+		net_conn = tls_conn
+	}
+	return newConn(net_conn), false, nil
+}
+
+// putConn keeps pooled for the next request to the same server, unless
+// there are MaxIdleConnsPerHost waiting already.
+func (c *Client) putConn(scheme, address string, pooled *conn) {
+	key := connKey(scheme, address)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.idle[key]) >= c.MaxIdleConnsPerHost {
+		pooled.close()
+		return
+	}
+	pooled.idle_since = time.Now()
+	c.idle[key] = append(c.idle[key], pooled)
+}
+
+// CloseIdleConnections closes the connections waiting in the pool.
+func (c *Client) CloseIdleConnections() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, idle := range c.idle {
+		for _, pooled := range idle {
+			pooled.close()
+		}
+		delete(c.idle, key)
+	}
+}
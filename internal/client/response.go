@@ -0,0 +1,15 @@
+package client
+
+import (
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+// Response is a parsed response together with the request that produced it.
+// Its Body must be read to the end and closed for the connection to be
+// reused.
+type Response struct {
+	*response.Response
+	// Request is the request that produced this response, the last one
+	// when redirects were followed.
+	Request *Request
+}
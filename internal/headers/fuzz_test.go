@@ -0,0 +1,80 @@
+package headers
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// parseAll parses the field lines in data up to the empty line, getting it
+// read_size more bytes at a time the way the request parser does.
+func parseAll(data []byte, read_size int) (Headers, error) {
+	h := NewHeaders()
+	available, parsed := 0, 0
+	for available < len(data) {
+		available = min(available+read_size, len(data))
+		for {
+			n, done, err := h.Parse(data[parsed:available])
+			if err != nil {
+				return nil, err
+			}
+			parsed += n
+			if done {
+				return h, nil
+			}
+			if n == 0 {
+				break
+			}
+		}
+	}
+	return h, nil
+}
+
+func FuzzHeadersParse(f *testing.F) {
+	// the header sections of the recorded requests of the request package
+	paths, _ := filepath.Glob(filepath.Join("..", "request", "testdata", "traffic", "*.http"))
+	for _, path := range paths {
+		sample, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		if _, fields, found := bytes.Cut(sample, []byte("\r\n")); found {
+			f.Add(fields)
+		}
+	}
+	f.Add([]byte("     Host: localhost:42069          \r\n\r\n"))
+	f.Add([]byte("Set-Person: lane-loves-go\r\nSet-Person: prime-loves-zig\r\n\r\n"))
+	f.Add([]byte("H©st: localhost:42069\r\n\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Test: A single call consumes one whole line or nothing
+		n, done, err := NewHeaders().Parse(data)
+		if n < 0 || n > len(data) {
+			t.Fatalf("consumed %d bytes of %d", n, len(data))
+		}
+		if err != nil && n != 0 {
+			t.Fatalf("consumed %d bytes and failed: %v", n, err)
+		}
+		if done && (n != 2 || !bytes.HasPrefix(data, []byte("\r\n"))) {
+			t.Fatalf("done after %d bytes of %q", n, data)
+		}
+		crlf_index := bytes.Index(data, []byte("\r\n"))
+		if n > 0 && n != crlf_index+2 {
+			t.Fatalf("consumed %d bytes but the line ends at %d", n, crlf_index)
+		}
+		if err == nil && n == 0 && crlf_index != -1 {
+			t.Fatalf("consumed nothing of a complete line: %q", data)
+		}
+
+		// Test: Same headers however the input is split
+		whole, whole_err := parseAll(data, len(data))
+		for _, read_size := range []int{1, 2, 5} {
+			split, split_err := parseAll(data, read_size)
+			if (whole_err == nil) != (split_err == nil) || !reflect.DeepEqual(whole, split) {
+				t.Fatalf("reading %d bytes at a time gives %v, %v instead of %v, %v", read_size, split, split_err, whole, whole_err)
+			}
+		}
+	})
+}
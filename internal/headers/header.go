@@ -1,17 +1,19 @@
 package headers
 
 import (
+	"bytes"
 	"errors"
-	"regexp"
 	"strings"
 )
 
 type Headers map[string]string
 
+// Parse parses the first field line in data into h and returns how many bytes
+// it took, or done with 2 for the empty line that ends the headers. Nothing
+// is consumed until data holds a whole line. It only copies out the name and
+// the value, so it can be called on a buffer that is reused afterwards.
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
-	headers_string := string(data)
-
-	crlf_first_occurrence := strings.Index(headers_string, "\r\n")
+	crlf_first_occurrence := bytes.Index(data, crlf)
 	if crlf_first_occurrence == -1 {
 		return 0, false, nil
 	}
@@ -20,41 +22,129 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return 2, true, nil
 	}
 
-	headers_from_data := strings.Split(headers_string, "\r\n")
-	first_colon_occurrence := strings.Index(headers_from_data[0], ":")
+	line := data[:crlf_first_occurrence]
+	first_colon_occurrence := bytes.IndexByte(line, ':')
 	if first_colon_occurrence == -1 {
 		return 0, false, errors.New("a header/field-line should contain a \":\" to split field-name and field-value")
 	}
 	// ex: header = "  Host : localhost:42069  "
-	header_name := headers_from_data[0][:first_colon_occurrence]    // = "  Host "
-	header_value := headers_from_data[0][first_colon_occurrence+1:] // = " localhost:42069  "
-	header_name = strings.TrimLeft(header_name, " ")                // = "Host "
-	header_value = strings.Trim(header_value, " ")                  // = "localhost:42069"
+	header_name := line[:first_colon_occurrence]    // = "  Host "
+	header_value := line[first_colon_occurrence+1:] // = " localhost:42069  "
+	header_name = bytes.TrimLeft(header_name, " ")  // = "Host "
+	header_value = bytes.Trim(header_value, " \t")  // = "localhost:42069", OWS is SP or HTAB
 
 	if len(header_name) < 1 {
 		return 0, false, errors.New("header-name must be at least of length 1")
 	}
 
-	if strings.Contains(header_name, " ") {
+	if bytes.IndexByte(header_name, ' ') != -1 {
 		return 0, false, errors.New("the field-name in header/field-line must not contain whitespaces after it (i.e. before the colon)")
 	}
 
-	match, err := regexp.MatchString("^[A-Za-z0-9!#$%&'*+-.^_`|~]+$", header_name)
-	if err != nil {
-		return 0, false, err
-	}
-	if !match {
+	if !isTokenBytes(header_name) {
 		return 0, false, errors.New("header-name can contain only: capital letters, small letters, digits, and special characters (!,#,$,%,&,',*,+,-,.,^,_,`,|,~)")
 	}
 
-	if value, ok := h[strings.ToLower(header_name)]; ok {
-		if value != header_value {
-			h[strings.ToLower(header_name)] += ", " + header_value
+	if !validFieldValue(header_value) {
+		return 0, false, errors.New("header-value must not contain control characters (a bare CR or LF, NUL, ...)")
+	}
+
+	key := lowerString(header_name)
+	if _, ok := h[key]; ok {
+		separator := ", "
+		if key == "cookie" {
+			// the lines are joined as one list of cookie-pairs, the way
+			// HTTP/2 joins them (RFC 9113 section 8.2.3)
+			separator = "; "
 		}
+		h[key] += separator + string(header_value)
 	} else {
-		h[strings.ToLower(header_name)] = header_value
+		h[key] = string(header_value)
 	}
-	consumed_bytes := len(headers_from_data[0]) + /*crlf; because we removed it on split*/ 2
+	consumed_bytes := len(line) + /*crlf*/ 2
 
 	return consumed_bytes, false, nil
 }
+
+var crlf = []byte("\r\n")
+
+// validFieldValue reports whether value has nothing but visible characters,
+// spaces and tabs. A bare CR or LF in particular would end the line for a
+// parser less strict than this one.
+func validFieldValue(value []byte) bool {
+	for _, char := range value {
+		if (char < ' ' && char != '\t') || char == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// lowerString returns name lowercased as a new string, with a single
+// allocation.
+func lowerString(name []byte) string {
+	lower := strings.Builder{}
+	lower.Grow(len(name))
+	for _, char := range name {
+		if char >= 'A' && char <= 'Z' {
+			char += 'a' - 'A'
+		}
+		lower.WriteByte(char)
+	}
+	return lower.String()
+}
+
+// Set replaces the value of the header key; keys are stored lowercased,
+// the same way Parse stores them.
+func (h Headers) Set(key, value string) {
+	h[strings.ToLower(key)] = value
+}
+
+func (h Headers) Get(key string) (value string, found bool) {
+	value, found = h[strings.ToLower(key)]
+	return
+}
+
+func (h Headers) Delete(key string) {
+	delete(h, strings.ToLower(key))
+}
+
+// tokenChars marks the characters RFC 9110 allows in a token.
+var tokenChars = func() (table [256]bool) {
+	for char := 'a'; char <= 'z'; char++ {
+		table[char] = true
+		table[char-'a'+'A'] = true
+	}
+	for char := '0'; char <= '9'; char++ {
+		table[char] = true
+	}
+	for _, char := range "!#$%&'*+-.^_`|~" {
+		table[char] = true
+	}
+	return table
+}()
+
+// IsToken reports whether s is an RFC 9110 token, the syntax of field-names
+// and methods.
+func IsToken(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !tokenChars[s[i]] {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isTokenBytes(b []byte) bool {
+	for _, char := range b {
+		if !tokenChars[char] {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+// ValidFieldName reports whether name is a valid field-name.
+func ValidFieldName(name string) bool {
+	return IsToken(name)
+}
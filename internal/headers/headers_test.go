@@ -33,6 +33,15 @@ func TestHeaderParse(t *testing.T) {
 	assert.Equal(t, 38, n)
 	assert.False(t, done)
 
+	// Test: Tabs around the value are whitespace too
+	headers = NewHeaders()
+	data = []byte("Content-Length:\t 5\t\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "5", headers["content-length"])
+	assert.Equal(t, 21, n)
+	assert.False(t, done)
+
 	// Test: Invalid spacing header
 	headers = NewHeaders()
 	data = []byte("       Host : localhost:42069       \r\n\r\n")
@@ -107,4 +116,28 @@ func TestHeaderParse(t *testing.T) {
 	assert.Equal(t, "lane-loves-go, prime-loves-zig, tj-loves-ocaml", headers["set-person"])
 	assert.Equal(t, 28, n)
 	assert.False(t, done)
+
+	// Test: Control characters in the value
+	for _, line := range []string{"X-Bad: a\rb\r\n", "X-Bad: a\nb\r\n", "X-Bad: a\x00b\r\n"} {
+		headers = NewHeaders()
+		n, done, err = headers.Parse([]byte(line))
+		require.Error(t, err, "%q", line)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+	}
+
+	// Test: Tab inside the value is allowed
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Tab: a\tb\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a\tb", headers["x-tab"])
+
+	// Test: Every line of a repeated field is kept, identical or not
+	headers = NewHeaders()
+	for _, line := range []string{"Accept: a\r\n", "Accept: a\r\n", "Cookie: x=1\r\n", "Cookie: x=1\r\n"} {
+		_, _, err = headers.Parse([]byte(line))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "a, a", headers["accept"])
+	assert.Equal(t, "x=1; x=1", headers["cookie"])
 }
@@ -0,0 +1,251 @@
+package metrics
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DEFAULT_BUCKETS are the histogram upper bounds (in seconds) Prometheus
+// client libraries use by default; they fit request latencies well.
+var DEFAULT_BUCKETS = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	COUNTER   metricType = "counter"
+	GAUGE     metricType = "gauge"
+	HISTOGRAM metricType = "histogram"
+)
+
+// label values of a series are joined with this separator to build the map
+// key; it cannot appear in valid UTF-8 text.
+const labelSeparator = "\xff"
+
+// Registry owns a set of metrics and renders them in the Prometheus text
+// exposition format (version 0.0.4).
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type metric struct {
+	mu          sync.Mutex
+	name        string
+	help        string
+	metric_type metricType
+	label_names []string
+	buckets     []float64
+	series      map[string]*series
+}
+
+type series struct {
+	label_values []string
+	value        float64
+	bucket_count []uint64
+	count        uint64
+	sum          float64
+}
+
+func (r *Registry) register(m *metric) *metric {
+	m.series = map[string]*series{}
+	if len(m.label_names) == 0 {
+		// metrics without labels have exactly one series, expose it from the start
+		m.get(nil)
+	}
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+	return m
+}
+
+func (m *metric) get(label_values []string) *series {
+	if len(label_values) != len(m.label_names) {
+		panic("metrics: " + m.name + " expects " + strconv.Itoa(len(m.label_names)) + " label values, got " + strconv.Itoa(len(label_values)))
+	}
+	key := strings.Join(label_values, labelSeparator)
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{label_values: append([]string{}, label_values...)}
+		if m.metric_type == HISTOGRAM {
+			s.bucket_count = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+type Counter struct {
+	m *metric
+}
+
+func (r *Registry) NewCounter(name, help string, label_names ...string) *Counter {
+	return &Counter{m: r.register(&metric{name: name, help: help, metric_type: COUNTER, label_names: label_names})}
+}
+
+func (c *Counter) Inc(label_values ...string) {
+	c.Add(1, label_values...)
+}
+
+// Add increases the counter; negative values are ignored since counters only
+// go up.
+func (c *Counter) Add(value float64, label_values ...string) {
+	if value < 0 {
+		return
+	}
+	c.m.mu.Lock()
+	c.m.get(label_values).value += value
+	c.m.mu.Unlock()
+}
+
+func (c *Counter) Value(label_values ...string) float64 {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	return c.m.get(label_values).value
+}
+
+type Gauge struct {
+	m *metric
+}
+
+func (r *Registry) NewGauge(name, help string, label_names ...string) *Gauge {
+	return &Gauge{m: r.register(&metric{name: name, help: help, metric_type: GAUGE, label_names: label_names})}
+}
+
+func (g *Gauge) Set(value float64, label_values ...string) {
+	g.m.mu.Lock()
+	g.m.get(label_values).value = value
+	g.m.mu.Unlock()
+}
+
+func (g *Gauge) Add(value float64, label_values ...string) {
+	g.m.mu.Lock()
+	g.m.get(label_values).value += value
+	g.m.mu.Unlock()
+}
+
+func (g *Gauge) Inc(label_values ...string) {
+	g.Add(1, label_values...)
+}
+
+func (g *Gauge) Dec(label_values ...string) {
+	g.Add(-1, label_values...)
+}
+
+func (g *Gauge) Value(label_values ...string) float64 {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	return g.m.get(label_values).value
+}
+
+type Histogram struct {
+	m *metric
+}
+
+// NewHistogram registers a histogram with the given upper bounds; nil buckets
+// means DEFAULT_BUCKETS. The +Inf bucket is always implied.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, label_names ...string) *Histogram {
+	if buckets == nil {
+		buckets = DEFAULT_BUCKETS
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{m: r.register(&metric{name: name, help: help, metric_type: HISTOGRAM, label_names: label_names, buckets: buckets})}
+}
+
+func (h *Histogram) Observe(value float64, label_values ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.get(label_values)
+	for i, upper_bound := range h.m.buckets {
+		if value <= upper_bound {
+			s.bucket_count[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// WriteTo writes every registered metric in the Prometheus text format.
+// Series are sorted by label values so the output is stable.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*metric{}, r.metrics...)
+	r.mu.Unlock()
+
+	text := strings.Builder{}
+	for _, m := range metrics {
+		m.write(&text)
+	}
+	n, err := io.WriteString(w, text.String())
+	return int64(n), err
+}
+
+func (m *metric) write(text *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	text.WriteString("# HELP " + m.name + " " + escapeHelp(m.help) + "\n")
+	text.WriteString("# TYPE " + m.name + " " + string(m.metric_type) + "\n")
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.metric_type != HISTOGRAM {
+			text.WriteString(m.name + labels(m.label_names, s.label_values, "", "") + " " + formatFloat(s.value) + "\n")
+			continue
+		}
+		for i, upper_bound := range m.buckets {
+			text.WriteString(m.name + "_bucket" + labels(m.label_names, s.label_values, "le", formatFloat(upper_bound)) + " " + strconv.FormatUint(s.bucket_count[i], 10) + "\n")
+		}
+		text.WriteString(m.name + "_bucket" + labels(m.label_names, s.label_values, "le", "+Inf") + " " + strconv.FormatUint(s.count, 10) + "\n")
+		text.WriteString(m.name + "_sum" + labels(m.label_names, s.label_values, "", "") + " " + formatFloat(s.sum) + "\n")
+		text.WriteString(m.name + "_count" + labels(m.label_names, s.label_values, "", "") + " " + strconv.FormatUint(s.count, 10) + "\n")
+	}
+}
+
+func labels(names, values []string, extra_name, extra_value string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, name+"=\""+escapeLabelValue(values[i])+"\"")
+	}
+	if extra_name != "" {
+		pairs = append(pairs, extra_name+"=\""+extra_value+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"").Replace(s)
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	// Test: Counter with labels, sorted series
+	registry := NewRegistry()
+	requests := registry.NewCounter("http_requests_total", "Total requests.", "method", "status")
+	requests.Inc("POST", "201")
+	requests.Inc("GET", "200")
+	requests.Add(2, "GET", "200")
+	requests.Add(-5, "GET", "200")
+	out := &bytes.Buffer{}
+	_, err := registry.WriteTo(out)
+	require.NoError(t, err)
+	assert.Equal(t, "# HELP http_requests_total Total requests.\n"+
+		"# TYPE http_requests_total counter\n"+
+		"http_requests_total{method=\"GET\",status=\"200\"} 3\n"+
+		"http_requests_total{method=\"POST\",status=\"201\"} 1\n", out.String())
+
+	// Test: Gauge without labels
+	registry = NewRegistry()
+	active := registry.NewGauge("active_connections", "Open connections.")
+	active.Inc()
+	active.Inc()
+	active.Dec()
+	out = &bytes.Buffer{}
+	_, err = registry.WriteTo(out)
+	require.NoError(t, err)
+	assert.Equal(t, "# HELP active_connections Open connections.\n"+
+		"# TYPE active_connections gauge\n"+
+		"active_connections 1\n", out.String())
+
+	// Test: Histogram buckets are cumulative
+	registry = NewRegistry()
+	duration := registry.NewHistogram("duration_seconds", "Duration.", []float64{1, 0.1}, "method")
+	duration.Observe(0.05, "GET")
+	duration.Observe(0.5, "GET")
+	duration.Observe(3, "GET")
+	out = &bytes.Buffer{}
+	_, err = registry.WriteTo(out)
+	require.NoError(t, err)
+	assert.Equal(t, "# HELP duration_seconds Duration.\n"+
+		"# TYPE duration_seconds histogram\n"+
+		"duration_seconds_bucket{method=\"GET\",le=\"0.1\"} 1\n"+
+		"duration_seconds_bucket{method=\"GET\",le=\"1\"} 2\n"+
+		"duration_seconds_bucket{method=\"GET\",le=\"+Inf\"} 3\n"+
+		"duration_seconds_sum{method=\"GET\"} 3.55\n"+
+		"duration_seconds_count{method=\"GET\"} 3\n", out.String())
+
+	// Test: Label values are escaped
+	registry = NewRegistry()
+	errors := registry.NewCounter("errors_total", "Errors.", "type")
+	errors.Inc("bad \"quote\"\n")
+	out = &bytes.Buffer{}
+	_, err = registry.WriteTo(out)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "errors_total{type=\"bad \\\"quote\\\"\\n\"} 1\n")
+}
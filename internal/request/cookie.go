@@ -0,0 +1,62 @@
+package request
+
+import (
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+)
+
+// Cookie is a name/value pair the client sent in a Cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// Cookies returns the cookies of the request in the order the client sent
+// them (RFC 6265 section 5.4). Pairs that do not parse are skipped rather
+// than failing the request, since a client sends back whatever it was given.
+// Several Cookie headers are read as one, see headers.Headers.Parse.
+func (r *Request) Cookies() []Cookie {
+	cookie_header, ok := r.Get("Cookie")
+	if !ok {
+		return nil
+	}
+	cookies := []Cookie{}
+	for _, pair := range strings.Split(cookie_header, ";") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !headers.IsToken(name) || !ValidCookieValue(value) {
+			continue
+		}
+		if len(value) >= 2 && value[0] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// Cookie returns the value of the first cookie called name.
+func (r *Request) Cookie(name string) (value string, found bool) {
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == name {
+			return cookie.Value, true
+		}
+	}
+	return "", false
+}
+
+// ValidCookieValue reports whether value is an RFC 6265 cookie-value:
+// cookie-octets, optionally in double quotes. Whitespace, '"', ',', ';' and
+// '\' are not cookie-octets.
+func ValidCookieValue(value string) bool {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		char := value[i]
+		if char <= ' ' || char >= 0x7f || char == '"' || char == ',' || char == ';' || char == '\\' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package request
+
+type ErrorKind int
+
+const (
+	MALFORMED_REQUEST_LINE ErrorKind = iota
+	UNSUPPORTED_METHOD
+	UNSUPPORTED_VERSION
+	MALFORMED_HEADER
+	INVALID_BODY_LENGTH
+	INCOMPLETE_REQUEST
+	INVALID_TRANSFER_ENCODING
+	HEAD_TOO_LARGE
+	BODY_TOO_LARGE
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case MALFORMED_REQUEST_LINE:
+		return "malformed_request_line"
+	case UNSUPPORTED_METHOD:
+		return "unsupported_method"
+	case UNSUPPORTED_VERSION:
+		return "unsupported_version"
+	case MALFORMED_HEADER:
+		return "malformed_header"
+	case INVALID_BODY_LENGTH:
+		return "invalid_body_length"
+	case INCOMPLETE_REQUEST:
+		return "incomplete_request"
+	case INVALID_TRANSFER_ENCODING:
+		return "invalid_transfer_encoding"
+	case HEAD_TOO_LARGE:
+		return "head_too_large"
+	case BODY_TOO_LARGE:
+		return "body_too_large"
+	}
+	return "unknown"
+}
+
+// ParseError is returned by RequestFromReader when the bytes it read are not
+// a valid request. Kind tells the server how to answer (e.g. 400 vs 505).
+type ParseError struct {
+	Kind    ErrorKind
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return e.Message
+}
+
+func newParseError(kind ErrorKind, message string) error {
+	return &ParseError{Kind: kind, Message: message}
+}
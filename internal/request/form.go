@@ -0,0 +1,259 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+)
+
+var (
+	// ErrNotMultipart is returned for a request whose Content-Type is not
+	// multipart.
+	ErrNotMultipart = errors.New("request body is not multipart")
+	// ErrFormTooLarge is returned when a form is larger than allowed as a
+	// whole; a handler would answer it with 413.
+	ErrFormTooLarge = errors.New("form is larger than allowed")
+	// ErrPartTooLarge is returned when a single part of a multipart form is
+	// larger than allowed.
+	ErrPartTooLarge = errors.New("multipart part is larger than allowed")
+)
+
+// MAX_FORM_SIZE limits a URL-encoded request body that ParseForm decodes.
+const MAX_FORM_SIZE = 10 << 20
+
+// Defaults for the zero fields of MultipartLimits.
+const (
+	DEFAULT_MAX_MEMORY     int64 = 10 << 20
+	DEFAULT_MAX_PART_SIZE  int64 = 32 << 20
+	DEFAULT_MAX_TOTAL_SIZE int64 = 64 << 20
+)
+
+// MultipartLimits bounds what ParseMultipartForm accepts; zero fields take
+// the DEFAULT_ values. They are checked against r.Body, which was read into
+// memory whole before the handler ran: what a client can send at all is
+// bounded by Reader.MaxBodySize (the server's WithMaxBodySize), not by these.
+type MultipartLimits struct {
+	// MaxMemory is how many bytes of files are kept in memory; files past it
+	// are written to temporary files. Field values always stay in memory.
+	MaxMemory int64
+	// MaxPartSize limits the content of any one part.
+	MaxPartSize int64
+	// MaxTotalSize limits the content of all parts together.
+	MaxTotalSize int64
+}
+
+// MultipartForm is a parsed multipart/form-data body.
+type MultipartForm struct {
+	Value url.Values
+	File  map[string][]*FileHeader
+}
+
+// FileHeader is a file uploaded in a multipart form. Its content is in
+// memory, or in a temporary file if it did not fit.
+type FileHeader struct {
+	FileName string
+	Headers  headers.Headers
+	Size     int64
+
+	content  []byte
+	tmp_file string
+}
+
+// Open returns the content of the file.
+func (fh *FileHeader) Open() (io.ReadCloser, error) {
+	if fh.tmp_file != "" {
+		return os.Open(fh.tmp_file)
+	}
+	return io.NopCloser(bytes.NewReader(fh.content)), nil
+}
+
+// RemoveAll deletes the temporary files of the form. The server calls it
+// once the handler returns.
+func (f *MultipartForm) RemoveAll() error {
+	var first_err error
+	for _, files := range f.File {
+		for _, fh := range files {
+			if fh.tmp_file == "" {
+				continue
+			}
+			err := os.Remove(fh.tmp_file)
+			if err != nil && !errors.Is(err, os.ErrNotExist) && first_err == nil {
+				first_err = err
+			}
+		}
+	}
+	return first_err
+}
+
+// ParseForm fills r.Form with the fields of the query string and, for an
+// application/x-www-form-urlencoded body, r.PostForm with the fields of the
+// body. Body fields come first in r.Form. Calling it again does nothing.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+	r.Form = url.Values{}
+	r.PostForm = url.Values{}
+
+	content_type, _ := r.Get("Content-Type")
+	media_type, _, _ := mime.ParseMediaType(content_type)
+	if media_type == "application/x-www-form-urlencoded" {
+		if len(r.Body) > MAX_FORM_SIZE {
+			return ErrFormTooLarge
+		}
+		body_values, err := url.ParseQuery(string(r.Body))
+		if err != nil {
+			return errors.New("form body is malformed: " + err.Error())
+		}
+		for key, values := range body_values {
+			r.PostForm[key] = values
+			r.Form[key] = append(r.Form[key], values...)
+		}
+	}
+
+	_, query, found := strings.Cut(r.RequestLine.RequestTarget, "?")
+	if !found {
+		return nil
+	}
+	query_values, err := url.ParseQuery(query)
+	if err != nil {
+		return errors.New("query string is malformed: " + err.Error())
+	}
+	for key, values := range query_values {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	return nil
+}
+
+// FormValue returns the first value of the form field key, parsing the form
+// if that was not done yet.
+func (r *Request) FormValue(key string) string {
+	if r.Form == nil {
+		r.ParseMultipartForm(MultipartLimits{})
+	}
+	return r.Form.Get(key)
+}
+
+// ParseMultipartForm parses a multipart/form-data body into r.MultipartForm
+// and adds its field values to r.Form and r.PostForm, after running
+// ParseForm for the query string. Parts are read one at a time, and files
+// that do not fit in limits.MaxMemory any more are written to temporary
+// files, which RemoveAll deletes. Parts without a name are skipped. The parts
+// come from r.Body, which is in memory already, see MultipartLimits.
+func (r *Request) ParseMultipartForm(limits MultipartLimits) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+	err := r.ParseForm()
+	if err != nil {
+		return err
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	if limits.MaxMemory == 0 {
+		limits.MaxMemory = DEFAULT_MAX_MEMORY
+	}
+	if limits.MaxPartSize == 0 {
+		limits.MaxPartSize = DEFAULT_MAX_PART_SIZE
+	}
+	if limits.MaxTotalSize == 0 {
+		limits.MaxTotalSize = DEFAULT_MAX_TOTAL_SIZE
+	}
+
+	form := &MultipartForm{Value: url.Values{}, File: map[string][]*FileHeader{}}
+	memory_left := limits.MaxMemory
+	total_left := limits.MaxTotalSize
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			form.RemoveAll()
+			return err
+		}
+		if part.FormName == "" {
+			continue
+		}
+
+		limit := min(limits.MaxPartSize, total_left)
+		var content bytes.Buffer
+		if part.FileName == "" {
+			_, err = io.Copy(&content, io.LimitReader(part, limit+1))
+		} else {
+			// one byte past what fits in memory tells the file to spill
+			_, err = io.Copy(&content, io.LimitReader(part, min(limit, memory_left)+1))
+		}
+		if err != nil {
+			form.RemoveAll()
+			return err
+		}
+
+		size := int64(content.Len())
+		var fh *FileHeader
+		if part.FileName != "" {
+			fh = &FileHeader{FileName: part.FileName, Headers: part.Headers}
+			if size > memory_left && size <= limit {
+				fh.tmp_file, size, err = spillToFile(&content, part, limit)
+				if err != nil {
+					form.RemoveAll()
+					return err
+				}
+			}
+		}
+		if size > limit {
+			if fh != nil && fh.tmp_file != "" {
+				os.Remove(fh.tmp_file)
+			}
+			form.RemoveAll()
+			if limit == limits.MaxPartSize {
+				return ErrPartTooLarge
+			}
+			return ErrFormTooLarge
+		}
+		total_left -= size
+
+		if fh == nil {
+			form.Value[part.FormName] = append(form.Value[part.FormName], content.String())
+			continue
+		}
+		fh.Size = size
+		if fh.tmp_file == "" {
+			fh.content = content.Bytes()
+			memory_left -= size
+		}
+		form.File[part.FormName] = append(form.File[part.FormName], fh)
+	}
+
+	for key, values := range form.Value {
+		r.PostForm[key] = append(r.PostForm[key], values...)
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	r.MultipartForm = form
+	return nil
+}
+
+// spillToFile writes what was read of a file part into a temporary file,
+// followed by the rest of the part, up to one byte past limit so the caller
+// can tell a part that is too large.
+func spillToFile(read *bytes.Buffer, part *Part, limit int64) (string, int64, error) {
+	file, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	size, err := io.Copy(file, io.MultiReader(read, io.LimitReader(part, limit+1-int64(read.Len()))))
+	if err != nil {
+		os.Remove(file.Name())
+		return "", 0, err
+	}
+	return file.Name(), size, nil
+}
@@ -0,0 +1,180 @@
+package request
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// formRequest parses a POST to target with the given Content-Type and body.
+func formRequest(t *testing.T, target, content_type, body string) *Request {
+	t.Helper()
+	r, err := RequestFromReader(&chunkReader{
+		data: "POST " + target + " HTTP/1.1\r\nHost: localhost\r\nContent-Type: " + content_type +
+			"\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body,
+		numBytesPerRead: 1024,
+	})
+	require.NoError(t, err)
+	return r
+}
+
+const MULTIPART_BODY = "preamble\r\n" +
+	"--xyz\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+	"hello world\r\n" +
+	"--xyz\r\n" +
+	"Content-Disposition: form-data; name=\"upload\"; filename=\"C:\\\\docs\\\\notes.txt\"\r\n" +
+	"Content-Type: text/plain\r\n\r\n" +
+	"line one\r\n--xy not a boundary\r\nline two\r\n" +
+	"--xyz\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+	"second\r\n" +
+	"--xyz--\r\n" +
+	"epilogue"
+
+func TestParseForm(t *testing.T) {
+	// Test: Body fields come before query fields
+	r := formRequest(t, "/submit?name=query&page=2", "application/x-www-form-urlencoded", "name=J%C3%BCrgen&tags=a&tags=b+c")
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, []string{"Jürgen", "query"}, r.Form["name"])
+	assert.Equal(t, []string{"a", "b c"}, r.PostForm["tags"])
+	assert.Equal(t, "2", r.Form.Get("page"))
+	assert.Empty(t, r.PostForm["page"])
+	assert.Equal(t, "Jürgen", r.FormValue("name"))
+
+	// Test: Other content types only give the query
+	r = formRequest(t, "/submit?a=1", "text/plain", "b=2")
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "1", r.Form.Get("a"))
+	assert.Empty(t, r.PostForm)
+
+	// Test: Malformed encoding is an error
+	r = formRequest(t, "/submit", "application/x-www-form-urlencoded", "a=%zz")
+	require.Error(t, r.ParseForm())
+	r = formRequest(t, "/submit?a=%", "text/plain", "")
+	require.Error(t, r.ParseForm())
+
+	// Test: Body over MAX_FORM_SIZE is rejected
+	r = formRequest(t, "/submit", "application/x-www-form-urlencoded", "a="+strings.Repeat("x", MAX_FORM_SIZE))
+	require.ErrorIs(t, r.ParseForm(), ErrFormTooLarge)
+}
+
+func TestMultipartReader(t *testing.T) {
+	// Test: Parts are read with their headers, one byte at a time
+	mr, err := NewMultipartReader(iotest.OneByteReader(strings.NewReader(MULTIPART_BODY)), "xyz")
+	require.NoError(t, err)
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName)
+	assert.Equal(t, "", part.FileName)
+	content, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "upload", part.FormName)
+	assert.Equal(t, "notes.txt", part.FileName)
+	assert.Equal(t, "text/plain", part.Headers["content-type"])
+	content, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "line one\r\n--xy not a boundary\r\nline two", string(content))
+
+	// Test: NextPart skips what was not read of a part
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName)
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Body without preamble or parts
+	mr, err = NewMultipartReader(strings.NewReader("--xyz--"), "xyz")
+	require.NoError(t, err)
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Malformed bodies are errors
+	for _, body := range []string{
+		"no boundary at all",
+		"--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nno closing boundary",
+		"--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n",
+		"--xyzabc\r\n\r\nbody\r\n--xyz--",
+		"--xyz\r\nbad header\r\n\r\nbody\r\n--xyz--",
+		"--xyz\r\nX: " + strings.Repeat("a", MAX_PART_HEADER_BYTES) + "\r\n\r\nbody\r\n--xyz--",
+	} {
+		mr, err = NewMultipartReader(strings.NewReader(body), "xyz")
+		require.NoError(t, err)
+		part, err = mr.NextPart()
+		if err == nil {
+			_, err = io.ReadAll(part)
+		}
+		assert.Error(t, err, "%q", body)
+	}
+
+	// Test: Invalid boundaries
+	_, err = NewMultipartReader(strings.NewReader(""), "")
+	require.Error(t, err)
+	_, err = NewMultipartReader(strings.NewReader(""), strings.Repeat("b", 71))
+	require.Error(t, err)
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	// Test: Values and files are parsed, files kept in memory
+	r := formRequest(t, "/upload?from=query", "multipart/form-data; boundary=xyz", MULTIPART_BODY)
+	require.NoError(t, r.ParseMultipartForm(MultipartLimits{}))
+	assert.Equal(t, []string{"hello world", "second"}, r.MultipartForm.Value["title"])
+	assert.Equal(t, []string{"query", ""}, []string{r.Form.Get("from"), r.PostForm.Get("from")})
+	assert.Equal(t, "hello world", r.FormValue("title"))
+	require.Len(t, r.MultipartForm.File["upload"], 1)
+	fh := r.MultipartForm.File["upload"][0]
+	assert.Equal(t, "notes.txt", fh.FileName)
+	assert.Equal(t, int64(39), fh.Size)
+	assert.Empty(t, fh.tmp_file)
+	file, err := fh.Open()
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "line one\r\n--xy not a boundary\r\nline two", string(content))
+
+	// Test: Files past MaxMemory go to temporary files, removed by RemoveAll
+	r = formRequest(t, "/upload", "multipart/form-data; boundary=xyz", MULTIPART_BODY)
+	require.NoError(t, r.ParseMultipartForm(MultipartLimits{MaxMemory: 10}))
+	fh = r.MultipartForm.File["upload"][0]
+	require.NotEmpty(t, fh.tmp_file)
+	assert.Equal(t, int64(39), fh.Size)
+	file, err = fh.Open()
+	require.NoError(t, err)
+	content, err = io.ReadAll(file)
+	require.NoError(t, err)
+	file.Close()
+	assert.Equal(t, "line one\r\n--xy not a boundary\r\nline two", string(content))
+	require.NoError(t, r.MultipartForm.RemoveAll())
+	_, err = os.Stat(fh.tmp_file)
+	assert.True(t, os.IsNotExist(err))
+
+	// Test: Part and total limits
+	r = formRequest(t, "/upload", "multipart/form-data; boundary=xyz", MULTIPART_BODY)
+	require.ErrorIs(t, r.ParseMultipartForm(MultipartLimits{MaxPartSize: 38}), ErrPartTooLarge)
+	assert.Nil(t, r.MultipartForm)
+	r = formRequest(t, "/upload", "multipart/form-data; boundary=xyz", MULTIPART_BODY)
+	require.ErrorIs(t, r.ParseMultipartForm(MultipartLimits{MaxTotalSize: 55}), ErrFormTooLarge)
+	r = formRequest(t, "/upload", "multipart/form-data; boundary=xyz", MULTIPART_BODY)
+	require.ErrorIs(t, r.ParseMultipartForm(MultipartLimits{MaxMemory: 1, MaxTotalSize: 55}), ErrFormTooLarge)
+	r = formRequest(t, "/upload", "multipart/form-data; boundary=xyz", MULTIPART_BODY)
+	require.NoError(t, r.ParseMultipartForm(MultipartLimits{MaxPartSize: 39, MaxTotalSize: 56}))
+
+	// Test: Not multipart
+	r = formRequest(t, "/upload?a=1", "application/json", "{}")
+	require.ErrorIs(t, r.ParseMultipartForm(MultipartLimits{}), ErrNotMultipart)
+	assert.Equal(t, "1", r.Form.Get("a"))
+	r = formRequest(t, "/upload", "multipart/form-data", MULTIPART_BODY)
+	require.Error(t, r.ParseMultipartForm(MultipartLimits{}))
+}
@@ -0,0 +1,166 @@
+package request
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+)
+
+// MAX_CHUNK_SIZE_LINE limits the size line of a chunk, extensions included.
+const MAX_CHUNK_SIZE_LINE = 4096
+
+// startBody decides how the body of r is framed once its headers are parsed
+// (RFC 9112 section 6.3). Anything two parties could read differently is
+// rejected rather than guessed at, since a proxy in front of us that picks
+// the other reading would see a different request boundary than we do:
+// Transfer-Encoding together with Content-Length, codings other than a
+// single chunked, and Content-Length values that are not plain digits.
+func (r *Request) startBody() error {
+	transfer_encoding, chunked := r.Get("Transfer-Encoding")
+	content_length_string, has_length := r.Get("Content-Length")
+	if chunked && has_length {
+		return newParseError(INVALID_TRANSFER_ENCODING, "request must not have both Transfer-Encoding and Content-Length")
+	}
+
+	if chunked {
+		codings := strings.Split(transfer_encoding, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return newParseError(INVALID_TRANSFER_ENCODING, "\""+transfer_encoding+"\": chunked must be the final transfer coding")
+		}
+		if len(codings) > 1 {
+			return newParseError(INVALID_TRANSFER_ENCODING, "\""+transfer_encoding+"\": transfer coding is not supported")
+		}
+		r.Trailers = headers.Headers{}
+		r.ParserState = parsing_chunk_size
+		return nil
+	}
+
+	if !has_length {
+		r.ParserState = done
+		return nil
+	}
+	content_length, ok := parseContentLength(content_length_string)
+	if !ok {
+		return newParseError(INVALID_BODY_LENGTH, "Content-Length must be a number: "+content_length_string)
+	}
+	if err := r.checkBodySize(content_length); err != nil {
+		return err
+	}
+	r.content_length = content_length
+	r.ParserState = parsing_body
+	if content_length > 0 {
+		// the body grows in place instead of through repeated appends
+		r.Body = make([]byte, 0, min(content_length, BODY_PREALLOCATION_LIMIT))
+	} else if r.pipelined {
+		r.ParserState = done
+	}
+	return nil
+}
+
+// checkBodySize fails if a body of size bytes is over the limit of r, before
+// any of it is read.
+func (r *Request) checkBodySize(size int) error {
+	if r.max_body_size > 0 && size > r.max_body_size {
+		return newParseError(BODY_TOO_LARGE, "request body is larger than "+strconv.Itoa(r.max_body_size)+" bytes")
+	}
+	return nil
+}
+
+// parseContentLength accepts 1*DIGIT without leading zeros, which rules out
+// signs, lists of values (duplicate headers that disagree) and lengths that
+// overflow.
+func parseContentLength(value string) (int, bool) {
+	if value == "" || len(value) > 18 || (value[0] == '0' && len(value) > 1) {
+		return 0, false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, false
+		}
+	}
+	content_length, err := strconv.Atoi(value)
+	return content_length, err == nil
+}
+
+// parseChunked decodes a chunked body into r.Body, one part of the chunk
+// syntax per call: the size line, the chunk data, the CRLF after it, and
+// finally the trailer section.
+func (r *Request) parseChunked(data []byte) (int, error) {
+	switch r.ParserState {
+	case parsing_chunk_size:
+		crlf_index := bytes.Index(data, []byte("\r\n"))
+		// the limit holds whether or not the end of the line was read yet
+		if crlf_index > MAX_CHUNK_SIZE_LINE || (crlf_index == -1 && len(data) > MAX_CHUNK_SIZE_LINE) {
+			return 0, newParseError(INVALID_TRANSFER_ENCODING, "chunk size line is longer than "+strconv.Itoa(MAX_CHUNK_SIZE_LINE)+" bytes")
+		}
+		if crlf_index == -1 {
+			return 0, nil
+		}
+		chunk_size, err := parseChunkSize(data[:crlf_index])
+		if err != nil {
+			return 0, err
+		}
+		if err := r.checkBodySize(len(r.Body) + chunk_size); err != nil {
+			return 0, err
+		}
+		if chunk_size == 0 {
+			r.ParserState = parsing_trailers
+		} else {
+			r.chunk_remaining = chunk_size
+			r.ParserState = parsing_chunk_data
+		}
+		return crlf_index + 2, nil
+	case parsing_chunk_data:
+		n := min(len(data), r.chunk_remaining)
+		r.Body = append(r.Body, data[:n]...)
+		r.chunk_remaining -= n
+		if r.chunk_remaining == 0 {
+			r.ParserState = parsing_chunk_end
+		}
+		return n, nil
+	case parsing_chunk_end:
+		if len(data) == 0 || (len(data) == 1 && data[0] == '\r') {
+			return 0, nil
+		}
+		if data[0] != '\r' || data[1] != '\n' {
+			return 0, newParseError(INVALID_TRANSFER_ENCODING, "chunk data must be followed by CRLF")
+		}
+		r.ParserState = parsing_chunk_size
+		return 2, nil
+	default: // parsing_trailers
+		if err := rejectObsFold(data); err != nil {
+			return 0, err
+		}
+		n, trailers_done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, newParseError(MALFORMED_HEADER, err.Error())
+		}
+		if trailers_done {
+			r.ParserState = done
+		} else if n > 0 {
+			r.TrailerOrder = appendFieldName(r.TrailerOrder, data)
+		}
+		return n, nil
+	}
+}
+
+// parseChunkSize parses a chunk size line, a hex number optionally followed
+// by chunk extensions, which are ignored.
+func parseChunkSize(line []byte) (int, error) {
+	size, extensions, _ := bytes.Cut(line, []byte(";"))
+	size = bytes.TrimRight(size, " \t")
+	valid := len(size) > 0 && len(size) <= 15 // 15 hex digits cannot overflow
+	for _, char := range size {
+		valid = valid && ((char >= '0' && char <= '9') || (char >= 'a' && char <= 'f') || (char >= 'A' && char <= 'F'))
+	}
+	for _, char := range extensions {
+		valid = valid && (char >= ' ' || char == '\t') && char != 0x7f
+	}
+	if !valid {
+		return 0, newParseError(INVALID_TRANSFER_ENCODING, "\""+string(line)+"\": chunk size must be a hex number")
+	}
+	chunk_size, _ := strconv.ParseInt(string(size), 16, 64)
+	return int(chunk_size), nil
+}
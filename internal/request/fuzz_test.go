@@ -0,0 +1,179 @@
+package request
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// addTrafficSeeds adds the recorded requests in testdata/traffic to the seed
+// corpus of f.
+func addTrafficSeeds(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "traffic", "*.http"))
+	if err != nil || len(paths) == 0 {
+		f.Fatal("no traffic samples in testdata/traffic")
+	}
+	for _, path := range paths {
+		sample, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(sample)
+	}
+}
+
+// parseResult is everything a parse produced, to compare parses of the same
+// input that were read in different chunk sizes.
+type parseResult struct {
+	Request *Request
+	Err     string
+	// Rest is what a successful parse left: its buffered bytes followed by
+	// what it never read.
+	Rest string
+}
+
+func parseInChunks(data []byte, bytes_per_read int, pipelined bool) parseResult {
+	reader := &chunkReader{data: string(data), numBytesPerRead: bytes_per_read}
+	var req *Request
+	var err error
+	if pipelined {
+		req, err = NewReader(reader).ReadRequest()
+	} else {
+		req, err = RequestFromReader(reader)
+	}
+	if err != nil {
+		// how far a failed parse read depends on the reads, what failed not
+		return parseResult{Err: err.Error()}
+	}
+	result := parseResult{Rest: string(req.Buffered()) + reader.data[reader.pos:]}
+	req.buffered = nil
+	result.Request = req
+	return result
+}
+
+// checkParse asserts the invariants of parsing data: the parser does not
+// consume more than the input, and it comes to the same result however the
+// input is split into reads.
+func checkParse(t *testing.T, data []byte, pipelined bool) parseResult {
+	whole := parseInChunks(data, len(data)+1, pipelined)
+	if len(whole.Rest) > len(data) {
+		t.Fatalf("%d bytes left of a %d byte input", len(whole.Rest), len(data))
+	}
+	if whole.Request != nil {
+		consumed := len(data) - len(whole.Rest)
+		if len(whole.Request.Body) > consumed {
+			t.Fatalf("%d byte body out of %d consumed bytes", len(whole.Request.Body), consumed)
+		}
+		if !bytes.HasSuffix(data, []byte(whole.Rest)) {
+			t.Fatalf("leftover %q is not the end of the input", whole.Rest)
+		}
+	}
+	for _, bytes_per_read := range []int{1, 2, 3, 7, 64} {
+		split := parseInChunks(data, bytes_per_read, pipelined)
+		if !reflect.DeepEqual(whole, split) {
+			t.Fatalf("reading %d bytes at a time gives %+v instead of %+v", bytes_per_read, split, whole)
+		}
+	}
+	return whole
+}
+
+func FuzzRequestFromReader(f *testing.F) {
+	addTrafficSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkParse(t, data, false)
+	})
+}
+
+func FuzzReader(f *testing.F) {
+	addTrafficSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		result := checkParse(t, data, true)
+		if result.Request == nil || result.Request.RequestLine.Method == "CONNECT" {
+			return
+		}
+		// what is left is the next request, which parses the same on its own
+		reader := NewReader(&chunkReader{data: string(data), numBytesPerRead: 5})
+		reader.ReadRequest()
+		next, err := reader.ReadRequest()
+		alone := parseInChunks([]byte(result.Rest), 5, true)
+		if (err == nil) != (alone.Err == "") || (err == nil && !reflect.DeepEqual(next.RequestLine, alone.Request.RequestLine)) {
+			t.Fatalf("next request parses as %+v, %v in the stream and as %+v alone", next, err, alone)
+		}
+	})
+}
+
+func FuzzChunkedBody(f *testing.F) {
+	for _, seed := range []string{
+		"0\r\n\r\n",
+		"5\r\nhello\r\n0\r\n\r\n",
+		"1a;part=1\r\nabcdefghijklmnopqrstuvwxyz\r\n6\r\n012345\r\n0\r\nX-Checksum: 9a8b7c\r\n\r\n",
+		"5 ; a=\"b\"\r\nhello\r\n0\r\n\r\nGET / HTTP/1.1\r\n",
+		"ffffffffffffff\r\n",
+		"5\r\nhelloXX",
+	} {
+		f.Add([]byte(seed))
+	}
+	head := []byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n")
+	f.Fuzz(func(t *testing.T, chunked_body []byte) {
+		data := append(append([]byte{}, head...), chunked_body...)
+		result := checkParse(t, data, true)
+		if result.Request == nil {
+			return
+		}
+		// the decoded body and trailers come out of Write and back in unchanged
+		out := &bytes.Buffer{}
+		if err := result.Request.Write(out); err != nil {
+			t.Fatal(err)
+		}
+		again, err := RequestFromReader(out)
+		if err != nil {
+			t.Fatalf("%q does not parse: %v", out.String(), err)
+		}
+		if !bytes.Equal(again.Body, result.Request.Body) {
+			t.Fatalf("body %q came back as %q", result.Request.Body, again.Body)
+		}
+		if !reflect.DeepEqual(again.Trailers, result.Request.Trailers) {
+			t.Fatalf("trailers %q came back as %q", result.Request.Trailers, again.Trailers)
+		}
+	})
+}
+
+// readParts reads every part of a multipart body with the given read size,
+// and returns their names and contents followed by the error that ended it.
+func readParts(body []byte, bytes_per_read int) []string {
+	mr, err := NewMultipartReader(&chunkReader{data: string(body), numBytesPerRead: bytes_per_read}, "xyz")
+	if err != nil {
+		return []string{err.Error()}
+	}
+	parts := []string{}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return append(parts, err.Error())
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return append(parts, err.Error())
+		}
+		parts = append(parts, part.FormName+"/"+part.FileName+"="+string(content))
+	}
+}
+
+func FuzzMultipartReader(f *testing.F) {
+	f.Add([]byte(MULTIPART_BODY))
+	f.Add([]byte("--xyz--"))
+	f.Add([]byte("--xyz\r\n\r\n\r\n--xyz\r\n\r\n\r\n--xyz\r\n--xyz--"))
+	f.Add([]byte("--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n\r\n--xy\r\n--xyz \t\r\n\r\n--xyz--"))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		// Test: Parts do not depend on where reads split the body
+		expected := readParts(body, len(body)+1)
+		for _, bytes_per_read := range []int{1, 3, 17} {
+			if parts := readParts(body, bytes_per_read); !reflect.DeepEqual(expected, parts) {
+				t.Fatalf("%q read %d bytes at a time:\n%q\nread at once:\n%q", body, bytes_per_read, parts, expected)
+			}
+		}
+	})
+}
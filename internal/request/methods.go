@@ -0,0 +1,52 @@
+package request
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+)
+
+// Method describes a request method the server knows about. Safe methods are
+// read-only, idempotent ones can be retried without changing the outcome
+// (RFC 9110 9.2).
+type Method struct {
+	Name       string
+	Safe       bool
+	Idempotent bool
+}
+
+var (
+	methods_mutex     sync.RWMutex
+	registeredMethods = map[string]Method{
+		"GET":     {Name: "GET", Safe: true, Idempotent: true},
+		"HEAD":    {Name: "HEAD", Safe: true, Idempotent: true},
+		"POST":    {Name: "POST"},
+		"PUT":     {Name: "PUT", Idempotent: true},
+		"DELETE":  {Name: "DELETE", Idempotent: true},
+		"CONNECT": {Name: "CONNECT"},
+		"OPTIONS": {Name: "OPTIONS", Safe: true, Idempotent: true},
+		"TRACE":   {Name: "TRACE", Safe: true, Idempotent: true},
+		"PATCH":   {Name: "PATCH"},
+	}
+)
+
+// RegisterMethod adds an extension method (e.g. PROPFIND or PURGE) to the
+// ones RequestFromReader accepts. Methods are case-sensitive, "purge" and
+// "PURGE" are two different methods. A safe method is always idempotent.
+func RegisterMethod(name string, safe, idempotent bool) error {
+	if !headers.IsToken(name) {
+		return errors.New("\"" + name + "\": method must be a token")
+	}
+	methods_mutex.Lock()
+	defer methods_mutex.Unlock()
+	registeredMethods[name] = Method{Name: name, Safe: safe, Idempotent: safe || idempotent}
+	return nil
+}
+
+func LookupMethod(name string) (Method, bool) {
+	methods_mutex.RLock()
+	defer methods_mutex.RUnlock()
+	method, ok := registeredMethods[name]
+	return method, ok
+}
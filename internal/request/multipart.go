@@ -0,0 +1,221 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+)
+
+// MAX_PART_HEADER_BYTES limits the header section of a single part.
+const MAX_PART_HEADER_BYTES = 16 << 10
+
+// MultipartReader reads the parts of a multipart body (RFC 2046 section
+// 5.1.1) one after the other, so a part can be handled, or written
+// somewhere, before the next one is looked at.
+type MultipartReader struct {
+	reader *bufio.Reader
+	// "--" + boundary, the start of a delimiter line
+	dash_boundary []byte
+	// CRLF + dash_boundary, what ends the content of a part
+	delimiter []byte
+	current   *Part
+	started   bool
+	done      bool
+}
+
+// Part is one part of a multipart body. Reading it returns its content, up to
+// the delimiter in front of the next part.
+type Part struct {
+	Headers headers.Headers
+	// FormName and FileName are the name and filename parameters of the
+	// Content-Disposition header, if it has them. FileName is stripped of
+	// any directories the client put in front of it.
+	FormName string
+	FileName string
+
+	reader *MultipartReader
+	done   bool
+}
+
+// NewMultipartReader reads the multipart body in reader whose parts are
+// separated by boundary.
+func NewMultipartReader(reader io.Reader, boundary string) (*MultipartReader, error) {
+	if len(boundary) == 0 || len(boundary) > 70 || strings.ContainsAny(boundary, "\r\n") {
+		return nil, errors.New("\"" + boundary + "\": multipart boundary must be 1 to 70 characters on one line")
+	}
+	return &MultipartReader{
+		reader:        bufio.NewReader(reader),
+		dash_boundary: []byte("--" + boundary),
+		delimiter:     []byte("\r\n--" + boundary),
+	}, nil
+}
+
+// MultipartReader returns a MultipartReader over the body of r, which must
+// be multipart with a boundary parameter (multipart/form-data, but also
+// multipart/mixed and the like). Parts are streamed out of r.Body, but the
+// body itself was read whole before the handler ran, up to the limit of
+// Reader.MaxBodySize.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	content_type, _ := r.Get("Content-Type")
+	media_type, params, err := mime.ParseMediaType(content_type)
+	if err != nil || !strings.HasPrefix(media_type, "multipart/") {
+		return nil, ErrNotMultipart
+	}
+	boundary, ok := params["boundary"]
+	if !ok {
+		return nil, errors.New("multipart Content-Type has no boundary parameter")
+	}
+	return NewMultipartReader(bytes.NewReader(r.Body), boundary)
+}
+
+// NextPart skips what is left of the current part and returns the next one.
+// It returns io.EOF after the last part.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+	if mr.current != nil {
+		_, err := io.Copy(io.Discard, mr.current)
+		if err != nil {
+			return nil, err
+		}
+		mr.current = nil
+	}
+
+	var err error
+	if !mr.started {
+		err = mr.skipPreamble()
+		mr.started = true
+	} else {
+		err = mr.nextDelimiter()
+	}
+	if err != nil {
+		if err == io.EOF {
+			mr.done = true
+		}
+		return nil, err
+	}
+
+	part := &Part{Headers: headers.Headers{}, reader: mr}
+	header_bytes := 0
+	for {
+		line, err := mr.reader.ReadSlice('\n')
+		header_bytes += len(line)
+		if err == bufio.ErrBufferFull || header_bytes > MAX_PART_HEADER_BYTES {
+			return nil, errors.New("multipart part headers are longer than " + strconv.Itoa(MAX_PART_HEADER_BYTES) + " bytes")
+		}
+		if err != nil {
+			return nil, errors.New("multipart part headers have no ending")
+		}
+		_, headers_done, err := part.Headers.Parse(line)
+		if err != nil {
+			return nil, errors.New("multipart part header: " + err.Error())
+		}
+		if headers_done {
+			break
+		}
+	}
+	if disposition, ok := part.Headers.Get("Content-Disposition"); ok {
+		_, params, err := mime.ParseMediaType(disposition)
+		if err == nil {
+			part.FormName = params["name"]
+			part.FileName = baseName(params["filename"])
+		}
+	}
+	mr.current = part
+	return part, nil
+}
+
+// skipPreamble reads up to and including the first delimiter line; the text
+// in front of it is ignored. A body made of the close delimiter alone has no
+// parts.
+func (mr *MultipartReader) skipPreamble() error {
+	for {
+		line, err := mr.reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			continue // a long preamble line, which cannot be a delimiter
+		}
+		if bytes.HasPrefix(line, mr.dash_boundary) {
+			return mr.endDelimiter(line[len(mr.dash_boundary):])
+		}
+		if err != nil {
+			return errors.New("multipart body has no boundary")
+		}
+	}
+}
+
+// nextDelimiter reads the delimiter line that ended the content of the
+// current part.
+func (mr *MultipartReader) nextDelimiter() error {
+	_, err := mr.reader.Discard(len(mr.delimiter))
+	if err != nil {
+		return err
+	}
+	line, err := mr.reader.ReadSlice('\n')
+	if err != nil && !(err == io.EOF && bytes.HasPrefix(line, []byte("--"))) {
+		return errors.New("multipart delimiter line is malformed")
+	}
+	return mr.endDelimiter(line)
+}
+
+// endDelimiter checks what follows the boundary on a delimiter line: "--" for
+// the close delimiter, which returns io.EOF, otherwise only whitespace up to
+// the CRLF.
+func (mr *MultipartReader) endDelimiter(rest []byte) error {
+	if bytes.HasPrefix(rest, []byte("--")) {
+		return io.EOF // whatever follows is the epilogue
+	}
+	if !bytes.HasSuffix(rest, []byte("\r\n")) || len(bytes.TrimLeft(rest, " \t")) != 2 {
+		return errors.New("multipart delimiter line is malformed")
+	}
+	return nil
+}
+
+// Read reads the content of the part. It never consumes a byte of the
+// delimiter: whatever could be the start of one is held back until the
+// bytes after it show that it is not.
+func (p *Part) Read(b []byte) (int, error) {
+	if p.done {
+		return 0, io.EOF
+	}
+	mr := p.reader
+	peeked, err := mr.reader.Peek(len(mr.delimiter))
+	if len(peeked) < len(mr.delimiter) {
+		if err == io.EOF {
+			err = errors.New("multipart body has no closing boundary")
+		}
+		return 0, err
+	}
+	peeked, _ = mr.reader.Peek(mr.reader.Buffered())
+	if i := bytes.Index(peeked, mr.delimiter); i >= 0 {
+		if i == 0 {
+			p.done = true
+			return 0, io.EOF
+		}
+		peeked = peeked[:i]
+	} else {
+		peeked = peeked[:len(peeked)-len(mr.delimiter)+1]
+	}
+	n := copy(b, peeked)
+	mr.reader.Discard(n)
+	return n, nil
+}
+
+// baseName drops the directories a client may send along with a file name,
+// in either slash style, so the name cannot point outside of wherever it is
+// stored.
+func baseName(file_name string) string {
+	if i := strings.LastIndexAny(file_name, "/\\"); i >= 0 {
+		file_name = file_name[i+1:]
+	}
+	if file_name == "." || file_name == ".." {
+		return ""
+	}
+	return file_name
+}
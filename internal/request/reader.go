@@ -0,0 +1,50 @@
+package request
+
+import (
+	"io"
+)
+
+// Reader reads the requests a client sends on one connection, one after the
+// other. Unlike RequestFromReader it ends a body after Content-Length bytes,
+// and keeps whatever it read past a request for the next one, so requests a
+// client pipelines without waiting for the responses are all read, in order.
+type Reader struct {
+	// MaxBodySize limits the body of every request, 0 for no limit. Bodies
+	// are read into memory whole; a longer one, or one whose Content-Length
+	// says so, fails with a ParseError of kind BODY_TOO_LARGE.
+	MaxBodySize int
+
+	reader   io.Reader
+	buffered []byte
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{reader: reader}
+}
+
+// ReadRequest reads the next request. It returns io.EOF if the client closed
+// the connection in between two requests.
+func (r *Reader) ReadRequest() (*Request, error) {
+	req, leftover, err := readRequest(r.reader, r.buffered, true, r.MaxBodySize)
+	if err != nil {
+		r.buffered = nil
+		return nil, err
+	}
+	r.buffered = leftover
+	if len(leftover) > 0 {
+		req.buffered = leftover
+	}
+	return req, nil
+}
+
+// Buffered returns the bytes read past the last request.
+func (r *Reader) Buffered() []byte {
+	return r.buffered
+}
+
+// Unread hands back bytes that were read from the underlying reader by
+// someone else since the last request; they are parsed after the ones still
+// buffered.
+func (r *Reader) Unread(p []byte) {
+	r.buffered = append(r.buffered, p...)
+}
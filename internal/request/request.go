@@ -1,10 +1,14 @@
 package request
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"io"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/OmarJarbou/httpfromtcp/internal/headers"
 )
@@ -16,13 +20,69 @@ const (
 	parsing_headers
 	parsing_body
 	done
+	// a chunked body, see framing.go
+	parsing_chunk_size
+	parsing_chunk_data
+	parsing_chunk_end
+	parsing_trailers
 )
 
 type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
+	// Trailers holds the trailer section of a chunked body.
+	Trailers    headers.Headers
 	ParserState State
+	// HeaderOrder and TrailerOrder list the names of the fields in Headers
+	// and Trailers the way they were received: in order, spelled as sent and
+	// once per field. Write follows them.
+	HeaderOrder  []string
+	TrailerOrder []string
+	// RemoteAddr is the address of the client, filled in by the server.
+	RemoteAddr string
+	// Form, PostForm and MultipartForm are filled in by ParseForm and
+	// ParseMultipartForm, see form.go.
+	Form          url.Values
+	PostForm      url.Values
+	MultipartForm *MultipartForm
+
+	buffered []byte
+	ctx      context.Context
+	// set when reading from a Reader: the body ends after Content-Length
+	// bytes instead of at the end of the stream
+	pipelined      bool
+	content_length int
+	// bytes left of the current chunk of a chunked body
+	chunk_remaining int
+	// bytes of the request line and headers parsed so far
+	head_size int
+	// limit of the body, 0 for none, see Reader.MaxBodySize
+	max_body_size int
+}
+
+// Context returns the context of the request. The server cancels it when the
+// client goes away, the server shuts down or the request deadline passes;
+// handlers doing long work should give up once it is done.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of r that carries ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	copied := *r
+	copied.ctx = ctx
+	return &copied
+}
+
+// Buffered returns the bytes RequestFromReader (or a Reader) read past the
+// end of the request. They belong to whatever the client sent next, e.g. the
+// first bytes of a CONNECT tunnel.
+func (r *Request) Buffered() []byte {
+	return r.buffered
 }
 
 func (r *Request) Get(header_name string) (header_value string, found bool) {
@@ -36,112 +96,206 @@ type RequestLine struct {
 	Method        string
 }
 
-var supportedMethods map[string]struct{} = map[string]struct{}{
-	"GET":     {},
-	"HEAD":    {},
-	"POST":    {},
-	"PUT":     {},
-	"DELETE":  {},
-	"CONNECT": {},
-	"OPTIONS": {},
-	"TRACE":   {},
-}
+// BUFFER_SIZE is the size of the read buffers, which are pooled between
+// requests. It holds the head of a typical request in one read; longer ones
+// make the buffer grow.
+const BUFFER_SIZE int = 4096
+
+// MAX_HEAD_SIZE limits the request line and header section together; a
+// longer head is rejected with HEAD_TOO_LARGE before it is read completely.
+const MAX_HEAD_SIZE int = 64 << 10
 
-const BUFFER_SIZE int = 8
+// BODY_PREALLOCATION_LIMIT caps the memory reserved for a body up front on
+// the word of its Content-Length.
+const BODY_PREALLOCATION_LIMIT int = 1 << 20
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, BUFFER_SIZE)
+		return &buffer
+	},
+}
 
+// RequestFromReader reads a single request from reader. A body ends where
+// reader does, and must then match the Content-Length; use a Reader for a
+// connection that carries more requests after this one.
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	req, leftover, err := readRequest(reader, nil, false, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(leftover) > 0 {
+		req.buffered = leftover
+	}
+	return req, nil
+}
+
+// readRequest parses a request from the bytes in buffered followed by what is
+// read from reader, and returns it with the bytes read past its end. Bytes
+// are read into buffer[end:] and parsed from buffer[start:end]; the parsers
+// copy out what they keep, so the buffer goes back to the pool afterwards.
+func readRequest(reader io.Reader, buffered []byte, pipelined bool, max_body_size int) (*Request, []byte, error) {
 	req := Request{
-		RequestLine: RequestLine{},
-		Headers:     headers.Headers{},
-		Body:        []byte{},
-		ParserState: initialized,
+		RequestLine:   RequestLine{},
+		Headers:       headers.Headers{},
+		Body:          []byte{},
+		ParserState:   initialized,
+		pipelined:     pipelined,
+		max_body_size: max_body_size,
+	}
+	pooled := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(pooled)
+	buffer := *pooled
+	if len(buffered) >= len(buffer) {
+		buffer = make([]byte, 2*len(buffered))
+	}
+	start, end := 0, copy(buffer, buffered)
+	received := end > 0
+	if end > 0 {
+		// the whole request may be there already, and reading would block
+		p, err := req.parse(buffer[:end])
+		if err != nil {
+			return nil, nil, err
+		}
+		start += p
+		if err := req.checkHeadSize(end - start); err != nil {
+			return nil, nil, err
+		}
 	}
-	buffer := make([]byte, BUFFER_SIZE)
-	expand_index := 1
-	bytes_read_count := 0
-	bytes_parsed_count := 0
 
 	for req.ParserState != done {
-		n, err := reader.Read(buffer[bytes_read_count:])
+		if end == len(buffer) {
+			if start > 0 {
+				// make room by moving what is left to the front
+				end = copy(buffer, buffer[start:end])
+				start = 0
+			} else {
+				grown := make([]byte, 2*len(buffer))
+				copy(grown, buffer)
+				buffer = grown
+			}
+		}
+		n, err := reader.Read(buffer[end:])
+		if n > 0 {
+			// a reader may return the last bytes along with io.EOF
+			received = true
+			end += n
+			p, parse_err := req.parse(buffer[start:end])
+			if parse_err != nil {
+				return nil, nil, parse_err
+			}
+			start += p
+			if start == end {
+				start, end = 0, 0
+			}
+			if parse_err := req.checkHeadSize(end - start); parse_err != nil {
+				return nil, nil, parse_err
+			}
+		}
 		if err != nil {
+			if req.ParserState == done {
+				break
+			}
 			if err == io.EOF {
-				if req.ParserState == parsing_headers {
-					return nil, errors.New("header field has no ending")
-				} else if req.ParserState == parsing_body {
-					content_length_string, _ := req.Get("Content-Length")
-					content_length, err := strconv.Atoi(content_length_string)
-					if err != nil {
-						return nil, err
+				if req.ParserState == initialized {
+					if !received {
+						return nil, nil, io.EOF // the connection was closed without sending anything
 					}
-					if len(req.Body) == content_length {
+					return nil, nil, newParseError(INCOMPLETE_REQUEST, "request line has no ending")
+				} else if req.ParserState == parsing_headers {
+					return nil, nil, newParseError(INCOMPLETE_REQUEST, "header field has no ending")
+				} else if req.ParserState == parsing_body {
+					if len(req.Body) == req.content_length {
 						req.ParserState = done
-					} else if len(req.Body) < content_length {
-						return nil, errors.New("body of the request \"" + string(req.Body) + "\" is shorter than reported Content-Length")
-					} else if len(req.Body) > content_length {
-						return nil, errors.New("body of the request \"" + string(req.Body) + "\" is longer than reported Content-Length")
+					} else if len(req.Body) < req.content_length {
+						return nil, nil, newParseError(INVALID_BODY_LENGTH, "body of the request is shorter than reported Content-Length")
+					} else if len(req.Body) > req.content_length {
+						return nil, nil, newParseError(INVALID_BODY_LENGTH, "body of the request is longer than reported Content-Length")
 					}
+				} else {
+					return nil, nil, newParseError(INCOMPLETE_REQUEST, "chunked body of the request has no ending")
 				}
 				continue
 			} else {
-				return nil, err
+				return nil, nil, err
 			}
 		}
-		bytes_read_count += n
-		if bytes_read_count == len(buffer) {
-			temp_buf := buffer
-			buffer = make([]byte, BUFFER_SIZE<<(expand_index)) //BUFFER_SIZE*math.Pow(2, expand_index)
-			expand_index++
-			copy(buffer, temp_buf)
-		}
+	}
 
-		p, err := req.parse(buffer[:bytes_read_count])
-		if err != nil {
-			return nil, err
-		}
-		bytes_parsed_count += p
-		if p > 0 {
-			copy(buffer, buffer[p:]) //Remove the data that was parsed successfully buffer[0:p-1] from the buffer
-			bytes_read_count -= p
+	return &req, append([]byte{}, buffer[start:end]...), nil
+}
+
+// rejectObsFold fails for a field line that starts with whitespace. That is
+// obs-fold, a value continued from the line before, which a server must
+// reject (RFC 9112 section 5.2): headers.Parse would take it for a field of
+// its own, one an upstream may see as part of the previous value.
+func rejectObsFold(data []byte) error {
+	if len(data) > 0 && (data[0] == ' ' || data[0] == '\t') {
+		return newParseError(MALFORMED_HEADER, "a header/field-line must not start with whitespace (obsolete line folding is not supported)")
+	}
+	return nil
+}
+
+// appendFieldName adds the name of the field line data starts with to order,
+// unless a field of that name is in it already.
+func appendFieldName(order []string, data []byte) []string {
+	name, _, _ := bytes.Cut(data, []byte(":"))
+	for _, listed := range order {
+		if strings.EqualFold(listed, string(name)) {
+			return order
 		}
 	}
+	return append(order, string(name))
+}
 
-	return &req, nil
+// checkHeadSize fails once the head of r, what was parsed of it and the
+// pending bytes that are still to be, grows past MAX_HEAD_SIZE.
+func (r *Request) checkHeadSize(pending int) error {
+	if r.ParserState != initialized && r.ParserState != parsing_headers {
+		return nil
+	}
+	if r.head_size+pending > MAX_HEAD_SIZE {
+		return newParseError(HEAD_TOO_LARGE, "request line and headers are longer than "+strconv.Itoa(MAX_HEAD_SIZE)+" bytes")
+	}
+	return nil
 }
 
 func parseRequestLine(req_bytes []byte) (int, *RequestLine, error) {
-	req_line := RequestLine{}
-
-	req_string := string(req_bytes)
-	crlf_index := strings.Index(req_string, "\r\n")
+	crlf_index := bytes.Index(req_bytes, []byte("\r\n"))
 	if crlf_index == -1 {
 		return 0, nil, nil
 	}
-	req_parts := strings.Split(req_string, "\r\n")
+	line := req_bytes[:crlf_index]
 
-	req_line_parts := strings.Split(req_parts[0], " ")
-	if len(req_line_parts) != 3 {
-		return 0, nil, errors.New("request line must contain 3 fundamental parts: METHOD, RREQUEST TARGET, HTTP VERSION")
+	if bytes.Count(line, []byte(" ")) != 2 {
+		return 0, nil, newParseError(MALFORMED_REQUEST_LINE, "request line must contain 3 fundamental parts: METHOD, RREQUEST TARGET, HTTP VERSION")
 	}
+	method, rest, _ := bytes.Cut(line, []byte(" "))
+	target, version, _ := bytes.Cut(rest, []byte(" "))
 
-	for _, char := range req_line_parts[0] {
-		if string(char) < "A" || string(char) > "Z" {
-			return 0, nil, errors.New("\"" + req_line_parts[0] + "\": " + "method in request line must only contain capital alphabetic characters")
-		}
+	req_line := RequestLine{Method: string(method)}
+	if !headers.IsToken(req_line.Method) {
+		return 0, nil, newParseError(MALFORMED_REQUEST_LINE, "\""+req_line.Method+"\": "+"method in request line must be a token")
 	}
-	if _, ok := supportedMethods[req_line_parts[0]]; !ok {
-		return 0, nil, errors.New("\"" + req_line_parts[0] + "\": " + "method in request line should be one of the following: GET, HEAD, POST, PUT, DELETE, CONNECT, OPTIONS, TRACE")
+	if _, ok := LookupMethod(req_line.Method); !ok {
+		return 0, nil, newParseError(UNSUPPORTED_METHOD, "\""+req_line.Method+"\": "+"method is not implemented by this server")
+	}
+
+	if len(target) == 0 || bytes.ContainsFunc(target, func(char rune) bool { return char < ' ' || char == 0x7f }) {
+		return 0, nil, newParseError(MALFORMED_REQUEST_LINE, "request target must not be empty or contain control characters")
 	}
 
-	http_version_parts := strings.Split(req_line_parts[2], "/")
-	if len(http_version_parts) > 2 || http_version_parts[0] != "HTTP" || http_version_parts[1] != "1.1" {
-		return 0, nil, errors.New("http version in request line must be HTTP/1.1")
+	if bytes.Count(version, []byte("/")) != 1 || !bytes.HasPrefix(version, []byte("HTTP/")) {
+		return 0, nil, newParseError(MALFORMED_REQUEST_LINE, "\""+string(version)+"\": "+"http version in request line must look like HTTP/1.1")
+	}
+	if string(version[len("HTTP/"):]) != "1.1" {
+		return 0, nil, newParseError(UNSUPPORTED_VERSION, "http version in request line must be HTTP/1.1")
 	}
 
-	req_line.HttpVersion = http_version_parts[1]
-	req_line.RequestTarget = req_line_parts[1]
-	req_line.Method = req_line_parts[0]
+	req_line.HttpVersion = "1.1"
+	req_line.RequestTarget = string(target)
 
-	return len(req_parts[0]) + 2 /*for crlf*/, &req_line, nil
+	return len(line) + 2 /*for crlf*/, &req_line, nil
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -151,27 +305,20 @@ func (r *Request) parse(data []byte) (int, error) {
 	// currently available in the buffer. After successfully parsing each header, we remove its data
 	// from the buffer, ensuring that only the unparsed (incomplete) data remains
 
-	// While parsing request line is actualy done only one time, so it won't be affected
+	// The same goes for the request line and the body: the chunk that completes the request line
+	// can already hold headers (or even the whole request), so we keep going through the states
+	// until nothing more can be parsed from what we have
 
 	totalBytesParsed := 0
-	if r.ParserState == parsing_headers {
-		for !(r.ParserState == parsing_body || r.ParserState == done) {
-			n, err := r.parseSingle(data[totalBytesParsed:])
-			totalBytesParsed += n
-			if err != nil {
-				return totalBytesParsed, err
-			}
-			if n == 0 {
-				break
-			}
-		}
-	}
-	if r.ParserState == parsing_body || r.ParserState == initialized {
+	for r.ParserState != done {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		totalBytesParsed += n
 		if err != nil {
 			return totalBytesParsed, err
 		}
+		if n == 0 {
+			break
+		}
 	}
 	return totalBytesParsed, nil
 }
@@ -187,23 +334,29 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			r.RequestLine = *req_line
 			r.ParserState = parsing_headers
 		}
+		r.head_size += n
 		return n, nil
 	case parsing_headers:
+		if err := rejectObsFold(data); err != nil {
+			return 0, err
+		}
 		n, headers_done, err := r.Headers.Parse(data)
 		if err != nil {
-			return 0, err
+			return 0, newParseError(MALFORMED_HEADER, err.Error())
 		}
+		r.head_size += n
 		if headers_done {
-			if _, ok := r.Get("Content-Length"); !ok {
-				r.ParserState = done
-			} else {
-				r.ParserState = parsing_body
-			}
+			return n, r.startBody()
+		}
+		if n > 0 {
+			r.HeaderOrder = appendFieldName(r.HeaderOrder, data)
 		}
 		return n, nil
 	case parsing_body:
 		n := r.parseBody(data)
 		return n, nil
+	case parsing_chunk_size, parsing_chunk_data, parsing_chunk_end, parsing_trailers:
+		return r.parseChunked(data)
 	case done:
 		return 0, errors.New("error: trying to read data in a done state")
 	default:
@@ -212,6 +365,17 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 }
 
 func (r *Request) parseBody(data []byte) int {
+	if !r.pipelined {
+		r.Body = append(r.Body, data...)
+		return len(data)
+	}
+	missing := r.content_length - len(r.Body)
+	if len(data) > missing {
+		data = data[:missing]
+	}
 	r.Body = append(r.Body, data...)
+	if len(r.Body) == r.content_length {
+		r.ParserState = done
+	}
 	return len(data)
 }
@@ -1,8 +1,12 @@
 package request
 
 import (
+	"bytes"
 	"io"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -189,6 +193,17 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
 	assert.Equal(t, "*/*", r.Headers["accept"])
 
+	// Test: Request line and end of headers arrive in the same read
+	reader = &chunkReader{
+		data:            "POST /coffee?size=large HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 25,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/coffee?size=large", r.RequestLine.RequestTarget)
+	assert.Equal(t, "localhost", r.Headers["host"])
+
 	// Test: Malformed Header
 	reader = &chunkReader{
 		data:            "GET / HTTP/1.1\r\nHost localhost:42069\r\n\r\n",
@@ -214,7 +229,7 @@ func TestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
+	assert.Equal(t, "localhost:42069, localhost:42069", r.Headers["host"])
 
 	// Test: Same Header with differnt values
 	reader = &chunkReader{
@@ -244,6 +259,33 @@ func TestHeadersParse(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, r)
 	assert.Equal(t, "", r.Headers["host"])
+	// Test: Obsolete line folding is rejected, in headers and trailers
+	for _, data := range []string{
+		"GET / HTTP/1.1\r\nHost: localhost\r\nX-Folded: a\r\n b\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: localhost\r\n\tContent-Length: 5\r\n\r\nhello",
+		"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-Sum: 1\r\n 2\r\n\r\n",
+	} {
+		_, err = RequestFromReader(&chunkReader{data: data, numBytesPerRead: 3})
+		var parse_err *ParseError
+		require.ErrorAs(t, err, &parse_err)
+		assert.Equal(t, MALFORMED_HEADER, parse_err.Kind)
+	}
+
+	// Test: Head at the size limit
+	filler := "X-Filler: " + strings.Repeat("a", MAX_HEAD_SIZE) + "\r\n"
+	head := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	head += "X-Last: " + strings.Repeat("b", MAX_HEAD_SIZE-len(head)-len("X-Last: \r\n\r\n")) + "\r\n\r\n"
+	r, err = RequestFromReader(strings.NewReader(head))
+	require.NoError(t, err)
+	require.NotNil(t, r)
+
+	// Test: Head over the size limit, also when it never ends
+	for _, data := range []string{"GET / HTTP/1.1\r\n" + filler + "\r\n", "GET / HTTP/1.1\r\nX-Filler: " + strings.Repeat("a", 2*MAX_HEAD_SIZE)} {
+		_, err = RequestFromReader(&chunkReader{data: data, numBytesPerRead: 1000})
+		var parse_err *ParseError
+		require.ErrorAs(t, err, &parse_err)
+		assert.Equal(t, HEAD_TOO_LARGE, parse_err.Kind)
+	}
 }
 
 func TestBodyParse(t *testing.T) {
@@ -334,4 +376,424 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
+
+	// Test: Framing headers separated from their values by tabs
+	for _, data := range []string{
+		"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length:\t5\t\r\n\r\nhello",
+		"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding:\tchunked\t\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+	} {
+		r, err = RequestFromReader(&chunkReader{data: data, numBytesPerRead: 3})
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(r.Body))
+	}
+
+	// Test: Last bytes returned together with io.EOF
+	for _, data := range []string{
+		"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello",
+		"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+	} {
+		r, err = RequestFromReader(iotest.DataErrReader(strings.NewReader(data)))
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(r.Body))
+	}
+}
+
+func TestMethods(t *testing.T) {
+	// Test: PATCH is known by default
+	reader := &chunkReader{
+		data:            "PATCH /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "PATCH", r.RequestLine.Method)
+
+	// Test: Valid but unregistered method
+	reader = &chunkReader{
+		data:            "PURGE /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+	var parse_err *ParseError
+	require.ErrorAs(t, err, &parse_err)
+	assert.Equal(t, UNSUPPORTED_METHOD, parse_err.Kind)
+
+	// Test: Registered extension method
+	require.NoError(t, RegisterMethod("PURGE", false, true))
+	t.Cleanup(func() {
+		methods_mutex.Lock()
+		delete(registeredMethods, "PURGE")
+		methods_mutex.Unlock()
+	})
+	reader = &chunkReader{
+		data:            "PURGE /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "PURGE", r.RequestLine.Method)
+	method, ok := LookupMethod("PURGE")
+	require.True(t, ok)
+	assert.False(t, method.Safe)
+	assert.True(t, method.Idempotent)
+
+	// Test: Methods are case-sensitive
+	require.NoError(t, RegisterMethod("propfind", true, false))
+	method, ok = LookupMethod("propfind")
+	require.True(t, ok)
+	assert.True(t, method.Idempotent)
+	_, ok = LookupMethod("PROPFIND")
+	assert.False(t, ok)
+
+	// Test: Method that is not a token
+	require.Error(t, RegisterMethod("BAD/METHOD", false, false))
+	reader = &chunkReader{
+		data:            "GE(T /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorAs(t, err, &parse_err)
+	assert.Equal(t, MALFORMED_REQUEST_LINE, parse_err.Kind)
+}
+
+func TestRequestWrite(t *testing.T) {
+	// Test: Requests round trip byte for byte
+	for _, raw := range []string{
+		"GET / HTTP/1.1\r\nhost: localhost:42069\r\naccept: */*\r\nuser-agent: curl/7.81.0\r\n\r\n",
+		"POST /submit?x=1 HTTP/1.1\r\nhost: localhost:42069\r\ncontent-type: text/plain\r\ncontent-length: 13\r\n\r\nhello world!\n",
+		"DELETE /items/1 HTTP/1.1\r\nhost: localhost:42069\r\ncontent-length: 0\r\n\r\n",
+		"POST /upload HTTP/1.1\r\nhost: localhost:42069\r\ntrailer: x-checksum, x-count\r\ntransfer-encoding: chunked\r\n\r\n" +
+			"b\r\nhello world\r\n0\r\nx-checksum: 5eb63bbb\r\nx-count: 11\r\n\r\n",
+		"POST /upload HTTP/1.1\r\nhost: localhost:42069\r\ntransfer-encoding: chunked\r\n\r\n0\r\n\r\n",
+		"POST /Mixed HTTP/1.1\r\nUser-Agent: curl/7.81.0\r\nContent-Length: 5\r\nHost: localhost:42069\r\nX-Zeta: z\r\nAccept: */*\r\n\r\nhello",
+		"POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\nHOST: localhost:42069\r\nTrailer: X-Sum, x-Count\r\n\r\n" +
+			"5\r\nhello\r\n0\r\nx-Count: 5\r\nX-Sum: 1a2b\r\n\r\n",
+	} {
+		r, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: 3})
+		require.NoError(t, err)
+		out := &bytes.Buffer{}
+		require.NoError(t, r.Write(out))
+		assert.Equal(t, raw, out.String())
+	}
+
+	// Test: Headers are lowercased and sorted with host first, the length is the body's
+	r := &Request{
+		RequestLine: RequestLine{Method: "PUT", RequestTarget: "/data", HttpVersion: "1.1"},
+		Headers:     map[string]string{"x-b": "2", "x-a": "1", "host": "example.com", "content-length": "99"},
+		Body:        []byte("abc"),
+	}
+	out := &bytes.Buffer{}
+	require.NoError(t, r.Write(out))
+	assert.Equal(t, "PUT /data HTTP/1.1\r\nhost: example.com\r\nx-a: 1\r\nx-b: 2\r\ncontent-length: 3\r\n\r\nabc", out.String())
+	parsed, err := RequestFromReader(&chunkReader{data: out.String(), numBytesPerRead: 5})
+	require.NoError(t, err)
+	assert.Equal(t, r.Headers["x-a"], parsed.Headers["x-a"])
+	assert.Equal(t, "abc", string(parsed.Body))
+
+	// Test: Chunked body
+	r = &Request{
+		RequestLine: RequestLine{Method: "POST", RequestTarget: "/upload", HttpVersion: "1.1"},
+		Headers:     map[string]string{"host": "example.com", "transfer-encoding": "chunked", "content-length": "5"},
+		Body:        []byte("hello world"),
+	}
+	out = &bytes.Buffer{}
+	require.NoError(t, r.Write(out))
+	assert.Equal(t, "POST /upload HTTP/1.1\r\nhost: example.com\r\ntransfer-encoding: chunked\r\n\r\nb\r\nhello world\r\n0\r\n\r\n", out.String())
+
+	// Test: Requests that cannot be written
+	for _, bad := range []*Request{
+		{RequestLine: RequestLine{Method: "GE T", RequestTarget: "/"}},
+		{RequestLine: RequestLine{Method: "GET", RequestTarget: ""}},
+		{RequestLine: RequestLine{Method: "GET", RequestTarget: "/a b"}},
+		{RequestLine: RequestLine{Method: "GET", RequestTarget: "/"}, Headers: map[string]string{"x-evil": "1\r\nhost: other"}},
+		{RequestLine: RequestLine{Method: "GET", RequestTarget: "/"}, Headers: map[string]string{"bad name": "1"}},
+		{RequestLine: RequestLine{Method: "POST", RequestTarget: "/"}, Headers: map[string]string{"transfer-encoding": "gzip"}},
+		{RequestLine: RequestLine{Method: "POST", RequestTarget: "/"}, Trailers: map[string]string{"x-checksum": "1"}},
+		{RequestLine: RequestLine{Method: "POST", RequestTarget: "/"}, Headers: map[string]string{"transfer-encoding": "chunked"}, Trailers: map[string]string{"x-evil": "1\r\n\r\nGET /"}},
+	} {
+		assert.Error(t, bad.Write(&bytes.Buffer{}), bad.RequestLine.Method+" "+bad.RequestLine.RequestTarget)
+	}
+}
+
+func TestReader(t *testing.T) {
+	// Test: Pipelined requests are read one after the other
+	reader := NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /second HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"PUT /third HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n" +
+			"POST /fourth HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc",
+		numBytesPerRead: 7,
+	})
+	for _, expected := range []struct{ target, body string }{
+		{"/first", "hello"},
+		{"/second", ""},
+		{"/third", ""},
+		{"/fourth", "abc"},
+	} {
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, expected.target, r.RequestLine.RequestTarget)
+		assert.Equal(t, expected.body, string(r.Body))
+	}
+	_, err := reader.ReadRequest()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Bytes read by someone else are parsed after the buffered ones
+	reader = NewReader(&chunkReader{
+		data:            "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\nGET /b HTTP/1.1\r\n",
+		numBytesPerRead: 100,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "GET /b HTTP/1.1\r\n", string(reader.Buffered()))
+	assert.Equal(t, reader.Buffered(), r.Buffered())
+	reader.Unread([]byte("Host: localhost\r\n\r\n"))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+
+	// Test: Body cut off by the end of the stream
+	reader = NewReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nshort",
+		numBytesPerRead: 3,
+	})
+	_, err = reader.ReadRequest()
+	var parse_err *ParseError
+	require.ErrorAs(t, err, &parse_err)
+	assert.Equal(t, INVALID_BODY_LENGTH, parse_err.Kind)
+
+	// Test: Content-Length that is not a number
+	reader = NewReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: ten\r\n\r\nsomething",
+		numBytesPerRead: 3,
+	})
+	_, err = reader.ReadRequest()
+	require.ErrorAs(t, err, &parse_err)
+	assert.Equal(t, INVALID_BODY_LENGTH, parse_err.Kind)
+	// Test: Bodies up to MaxBodySize are read, larger ones rejected before
+	// they are read, whatever their framing
+	reader = NewReader(&chunkReader{
+		data: "POST /a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+			"POST /b HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhel\r\n2\r\nlo\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	reader.MaxBodySize = 5
+	for i := 0; i < 2; i++ {
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(r.Body))
+	}
+	for _, data := range []string{
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 6\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhel\r\n3\r\n",
+	} {
+		reader = NewReader(&chunkReader{data: data, numBytesPerRead: 3})
+		reader.MaxBodySize = 5
+		_, err = reader.ReadRequest()
+		require.ErrorAs(t, err, &parse_err)
+		assert.Equal(t, BODY_TOO_LARGE, parse_err.Kind)
+	}
+}
+
+// benchmarkRequest is a typical browser request, followed by a body in the
+// body benchmark.
+const benchmarkRequest = "POST /api/v1/items?page=2&sort=desc HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0\r\n" +
+	"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n" +
+	"Accept-Language: en-US,en;q=0.5\r\n" +
+	"Accept-Encoding: gzip, deflate, br\r\n" +
+	"Referer: http://localhost:42069/items\r\n" +
+	"Cookie: session=0123456789abcdef; theme=dark\r\n" +
+	"Connection: keep-alive\r\n" +
+	"Cache-Control: no-cache\r\n" +
+	"Content-Type: application/json\r\n"
+
+func benchmarkRequestFromReader(b *testing.B, raw string, bytes_per_read int) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	for i := 0; i < b.N; i++ {
+		_, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: bytes_per_read})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	benchmarkRequestFromReader(b, benchmarkRequest+"Content-Length: 0\r\n\r\n", 1024)
+}
+
+func BenchmarkRequestFromReaderSmallReads(b *testing.B) {
+	benchmarkRequestFromReader(b, benchmarkRequest+"Content-Length: 0\r\n\r\n", 16)
+}
+
+func BenchmarkRequestFromReaderBody(b *testing.B) {
+	body := strings.Repeat("{\"id\": 1, \"name\": \"item\"}\n", 1024)
+	benchmarkRequestFromReader(b, benchmarkRequest+"Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body, 4096)
+}
+
+func BenchmarkReaderPipelined(b *testing.B) {
+	raw := strings.Repeat(benchmarkRequest+"Content-Length: 2\r\n\r\n{}", 16)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	for i := 0; i < b.N; i++ {
+		reader := NewReader(&chunkReader{data: raw, numBytesPerRead: 4096})
+		for j := 0; j < 16; j++ {
+			_, err := reader.ReadRequest()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestChunkedBody(t *testing.T) {
+	// Test: Chunked body with extensions and a trailer, read 1 byte at a time
+	raw := "POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5;name=value\r\nhello\r\n" +
+		"6 ; last\r\n world\r\n" +
+		"0\r\nX-Checksum: abc\r\n\r\n"
+	r, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(r.Body))
+	checksum, _ := r.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+
+	// Test: Chunked body of a pipelined request ends at the last chunk
+	reader := NewReader(&chunkReader{
+		data: "POST /a HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nA\r\n0123456789\r\n0\r\n\r\n" +
+			"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+
+	// Test: Chunk size line over the limit, whether it is read in one go or not
+	long_line := "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5;" + strings.Repeat("x", MAX_CHUNK_SIZE_LINE) + "\r\nhello\r\n0\r\n\r\n"
+	for _, bytes_per_read := range []int{1, len(long_line)} {
+		_, err = RequestFromReader(&chunkReader{data: long_line, numBytesPerRead: bytes_per_read})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "chunk size line is longer than")
+	}
+
+	// Test: Chunked body cut off
+	_, err = RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel",
+		numBytesPerRead: 3,
+	})
+	var parse_err *ParseError
+	require.ErrorAs(t, err, &parse_err)
+	assert.Equal(t, INCOMPLETE_REQUEST, parse_err.Kind)
+}
+
+func TestRequestSmuggling(t *testing.T) {
+	// Test: Payloads that two parsers could frame differently are rejected
+	head := "POST / HTTP/1.1\r\nHost: localhost\r\n"
+	for _, payload := range []struct {
+		raw  string
+		kind ErrorKind
+	}{
+		// CL.TE and TE.CL
+		{head + "Content-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG", INVALID_TRANSFER_ENCODING},
+		{head + "Transfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n", INVALID_TRANSFER_ENCODING},
+		// obfuscated or unsupported transfer codings
+		{head + "Transfer-Encoding: xchunked\r\n\r\n0\r\n\r\n", INVALID_TRANSFER_ENCODING},
+		{head + "Transfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n", INVALID_TRANSFER_ENCODING},
+		{head + "Transfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n", INVALID_TRANSFER_ENCODING},
+		{head + "Transfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\n\r\n0\r\n\r\n", INVALID_TRANSFER_ENCODING},
+		{head + "Transfer-Encoding: chunked\r\nTransfer-encoding: cow\r\n\r\n0\r\n\r\n", INVALID_TRANSFER_ENCODING},
+		{head + "Transfer-Encoding : chunked\r\n\r\n0\r\n\r\n", MALFORMED_HEADER},
+		{head + "Transfer-Encoding\t: chunked\r\n\r\n0\r\n\r\n", MALFORMED_HEADER},
+		{head + "Transfer-Encoding: \x0bchunked\r\n\r\n0\r\n\r\n", MALFORMED_HEADER},
+		{head + "X: x\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", MALFORMED_HEADER},
+		// Content-Length that is not a plain number, or more than one
+		{head + "Content-Length: +5\r\n\r\nhello", INVALID_BODY_LENGTH},
+		{head + "Content-Length: -1\r\n\r\n", INVALID_BODY_LENGTH},
+		{head + "Content-Length: 05\r\n\r\nhello", INVALID_BODY_LENGTH},
+		{head + "Content-Length: 0x5\r\n\r\nhello", INVALID_BODY_LENGTH},
+		{head + "Content-Length: 5 5\r\n\r\nhello", INVALID_BODY_LENGTH},
+		{head + "Content-Length: 5, 5\r\n\r\nhello", INVALID_BODY_LENGTH},
+		{head + "Content-Length: 5\r\nContent-Length: 6\r\n\r\nhello!", INVALID_BODY_LENGTH},
+		{head + "Content-Length: 99999999999999999999\r\n\r\n", INVALID_BODY_LENGTH},
+		// malformed chunks
+		{head + "Transfer-Encoding: chunked\r\n\r\n-5\r\nhello\r\n0\r\n\r\n", INVALID_TRANSFER_ENCODING},
+		{head + "Transfer-Encoding: chunked\r\n\r\n0x5\r\nhello\r\n0\r\n\r\n", INVALID_TRANSFER_ENCODING},
+		{head + "Transfer-Encoding: chunked\r\n\r\nfffffffffffffffff\r\n", INVALID_TRANSFER_ENCODING},
+		{head + "Transfer-Encoding: chunked\r\n\r\n5\r\nhelloXX0\r\n\r\n", INVALID_TRANSFER_ENCODING},
+		{head + "Transfer-Encoding: chunked\r\n\r\n5\nhello\r\n0\r\n\r\n", INVALID_TRANSFER_ENCODING},
+		{head + "Transfer-Encoding: chunked\r\n\r\n5;a\rb\r\nhello\r\n0\r\n\r\n", INVALID_TRANSFER_ENCODING},
+		// request line
+		{"POST /\x00 HTTP/1.1\r\nHost: localhost\r\n\r\n", MALFORMED_REQUEST_LINE},
+	} {
+		for _, bytes_per_read := range []int{1, 7, 1024} {
+			_, err := NewReader(&chunkReader{data: payload.raw, numBytesPerRead: bytes_per_read}).ReadRequest()
+			var parse_err *ParseError
+			require.ErrorAs(t, err, &parse_err, "%q", payload.raw)
+			assert.Equal(t, payload.kind, parse_err.Kind, "%q: %s", payload.raw, err)
+		}
+		_, err := RequestFromReader(&chunkReader{data: payload.raw, numBytesPerRead: 3})
+		assert.Error(t, err, "%q", payload.raw)
+	}
+
+	// Test: Content-Length sent twice is rejected even if the values agree,
+	// the lines are joined into a list like any other field
+	_, err := NewReader(&chunkReader{data: head + "Content-Length: 5\r\nContent-Length: 5\r\n\r\nhello", numBytesPerRead: 3}).ReadRequest()
+	var parse_err *ParseError
+	require.ErrorAs(t, err, &parse_err)
+	assert.Equal(t, INVALID_BODY_LENGTH, parse_err.Kind)
+}
+
+func TestCookies(t *testing.T) {
+	// Test: Pairs come back in the order they were sent
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: session=abc123; theme=dark;  quoted=\"v\"; empty=\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Cookie{{"session", "abc123"}, {"theme", "dark"}, {"quoted", "v"}, {"empty", ""}}, r.Cookies())
+	value, found := r.Cookie("theme")
+	assert.True(t, found)
+	assert.Equal(t, "dark", value)
+	_, found = r.Cookie("missing")
+	assert.False(t, found)
+
+	// Test: Two Cookie headers are both read
+	r, err = RequestFromReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: a=1\r\nCookie: b=2; a=3\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Cookie{{"a", "1"}, {"b", "2"}, {"a", "3"}}, r.Cookies())
+	value, _ = r.Cookie("a")
+	assert.Equal(t, "1", value)
+
+	// Test: Identical Cookie headers are not merged into one, and only ';'
+	// separates pairs
+	r, err = RequestFromReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: a=1\r\nCookie: a=1\r\nCookie: b=2,c=3\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Cookie{{"a", "1"}, {"a", "1"}}, r.Cookies())
+
+	// Test: Pairs that do not parse are skipped
+	r, err = RequestFromReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: novalue; bad name=1; ok=1; sp=a b; q=\"x; =v\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Cookie{{"ok", "1"}}, r.Cookies())
+
+	// Test: No Cookie header
+	r, err = RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", numBytesPerRead: 3})
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
 }
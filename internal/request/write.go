@@ -0,0 +1,140 @@
+package request
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+)
+
+// Write serializes r the way RequestFromReader parses it. Fields listed in
+// HeaderOrder and TrailerOrder are written first, in that order and with the
+// names spelled that way; the others follow lowercased and sorted by name,
+// host first and the framing headers last. The body is sent with a
+// Content-Length, or as a single chunk followed by r.Trailers if the request
+// says Transfer-Encoding: chunked. A request read with RequestFromReader is
+// written back byte for byte, unless it had a field on several lines (Headers
+// holds them joined, so they go out as one line) or a chunked body sent in
+// more than one chunk.
+func (r *Request) Write(writer io.Writer) error {
+	if !headers.IsToken(r.RequestLine.Method) {
+		return errors.New("\"" + r.RequestLine.Method + "\": method must be a token")
+	}
+	if r.RequestLine.RequestTarget == "" || strings.ContainsFunc(r.RequestLine.RequestTarget, isControlOrSpace) {
+		return errors.New("\"" + r.RequestLine.RequestTarget + "\": request target must not be empty or contain whitespace")
+	}
+	http_version := r.RequestLine.HttpVersion
+	if http_version == "" {
+		http_version = "1.1"
+	}
+
+	// the framing headers describe the body that is sent, not the one the
+	// request claims
+	fields := headers.Headers{}
+	for key, value := range r.Headers {
+		fields[key] = value
+	}
+	fields.Delete("Content-Length")
+	transfer_encoding, chunked := r.Get("Transfer-Encoding")
+	if chunked {
+		codings := strings.Split(transfer_encoding, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return errors.New("request body can only be framed with chunked, not " + transfer_encoding)
+		}
+	} else if _, has_content_length := r.Get("Content-Length"); has_content_length || len(r.Body) > 0 {
+		fields.Set("Content-Length", strconv.Itoa(len(r.Body)))
+	}
+
+	header_text, err := formatFields(fields, r.HeaderOrder)
+	if err != nil {
+		return err
+	}
+	trailer_text, err := formatFields(r.Trailers, r.TrailerOrder)
+	if err != nil {
+		return err
+	}
+	if trailer_text != "" && !chunked {
+		return errors.New("request trailers can only be sent with a chunked body")
+	}
+
+	request_text := r.RequestLine.Method + " " + r.RequestLine.RequestTarget + " HTTP/" + http_version + "\r\n" + header_text + "\r\n"
+	_, err = io.WriteString(writer, request_text)
+	if err != nil {
+		return err
+	}
+	if chunked {
+		chunk := ""
+		if len(r.Body) > 0 {
+			chunk = strconv.FormatInt(int64(len(r.Body)), 16) + "\r\n" + string(r.Body) + "\r\n"
+		}
+		_, err = io.WriteString(writer, chunk+"0\r\n"+trailer_text+"\r\n")
+		return err
+	}
+	_, err = writer.Write(r.Body)
+	return err
+}
+
+// formatFields returns the field lines of h: first those named in order,
+// spelled as they are there, then the rest lowercased and sorted by name,
+// with host first and the framing headers last.
+func formatFields(h headers.Headers, order []string) (string, error) {
+	for key, value := range h {
+		if !headers.ValidFieldName(key) {
+			return "", errors.New("\"" + key + "\": header name must be a token")
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return "", errors.New("value of header " + key + " must not contain CR, LF or NUL")
+		}
+	}
+
+	fields_text := ""
+	written := map[string]bool{}
+	for _, name := range order {
+		key := strings.ToLower(name)
+		value, ok := h.Get(key)
+		if !ok || written[key] {
+			continue
+		}
+		if !headers.ValidFieldName(name) {
+			return "", errors.New("\"" + name + "\": header name must be a token")
+		}
+		fields_text += name + ": " + value + "\r\n"
+		written[key] = true
+	}
+
+	keys := []string{}
+	for key := range h {
+		if !written[strings.ToLower(key)] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		rank_i, rank_j := fieldRank(keys[i]), fieldRank(keys[j])
+		if rank_i != rank_j {
+			return rank_i < rank_j
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
+		fields_text += strings.ToLower(key) + ": " + h[key] + "\r\n"
+	}
+	return fields_text, nil
+}
+
+// fieldRank places host before and the framing headers after the others.
+func fieldRank(key string) int {
+	switch strings.ToLower(key) {
+	case "host":
+		return 0
+	case "content-length", "transfer-encoding":
+		return 2
+	}
+	return 1
+}
+
+func isControlOrSpace(char rune) bool {
+	return char <= ' ' || char == 0x7f
+}
@@ -0,0 +1,148 @@
+package response
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+)
+
+type SameSite int
+
+const (
+	// SAME_SITE_DEFAULT leaves the attribute out and the browser decides
+	SAME_SITE_DEFAULT SameSite = iota
+	SAME_SITE_LAX
+	SAME_SITE_STRICT
+	SAME_SITE_NONE
+)
+
+// EXPIRES_FORMAT is the IMF-fixdate format cookie dates are sent in.
+const EXPIRES_FORMAT = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Cookie is a cookie set on the client with a Set-Cookie header (RFC 6265
+// section 4.1).
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path   string
+	Domain string
+	// Expires is left out when zero.
+	Expires time.Time
+	// MaxAge is left out when 0; a negative MaxAge deletes the cookie right
+	// away and is sent as Max-Age=0.
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Valid checks c against the grammar of RFC 6265, plus the rules browsers
+// enforce on top of it: SameSite=None and Partitioned need Secure.
+func (c *Cookie) Valid() error {
+	if !headers.IsToken(c.Name) {
+		return errors.New("\"" + c.Name + "\": cookie name must be a token")
+	}
+	if !request.ValidCookieValue(c.Value) {
+		return errors.New("\"" + c.Value + "\": value of cookie " + c.Name + " contains characters a cookie cannot hold")
+	}
+	if strings.ContainsFunc(c.Path, func(char rune) bool { return char < ' ' || char == 0x7f || char == ';' }) {
+		return errors.New("\"" + c.Path + "\": path of cookie " + c.Name + " must not contain control characters or ';'")
+	}
+	if c.Domain != "" && !validCookieDomain(c.Domain) {
+		return errors.New("\"" + c.Domain + "\": domain of cookie " + c.Name + " is not a domain name")
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return errors.New("expiry of cookie " + c.Name + " must not be before 1601")
+	}
+	if c.SameSite < SAME_SITE_DEFAULT || c.SameSite > SAME_SITE_NONE {
+		return errors.New("unknown SameSite value for cookie " + c.Name)
+	}
+	if c.SameSite == SAME_SITE_NONE && !c.Secure {
+		return errors.New("cookie " + c.Name + " with SameSite=None must be Secure")
+	}
+	if c.Partitioned && !c.Secure {
+		return errors.New("partitioned cookie " + c.Name + " must be Secure")
+	}
+	return nil
+}
+
+// String returns c as the value of a Set-Cookie header. It does not check c,
+// see Valid.
+func (c *Cookie) String() string {
+	cookie_text := c.Name + "=" + c.Value
+	if c.Path != "" {
+		cookie_text += "; Path=" + c.Path
+	}
+	if c.Domain != "" {
+		cookie_text += "; Domain=" + strings.TrimPrefix(c.Domain, ".")
+	}
+	if !c.Expires.IsZero() {
+		cookie_text += "; Expires=" + c.Expires.UTC().Format(EXPIRES_FORMAT)
+	}
+	if c.MaxAge > 0 {
+		cookie_text += "; Max-Age=" + strconv.Itoa(c.MaxAge)
+	} else if c.MaxAge < 0 {
+		cookie_text += "; Max-Age=0"
+	}
+	if c.Secure {
+		cookie_text += "; Secure"
+	}
+	if c.HttpOnly {
+		cookie_text += "; HttpOnly"
+	}
+	switch c.SameSite {
+	case SAME_SITE_LAX:
+		cookie_text += "; SameSite=Lax"
+	case SAME_SITE_STRICT:
+		cookie_text += "; SameSite=Strict"
+	case SAME_SITE_NONE:
+		cookie_text += "; SameSite=None"
+	}
+	if c.Partitioned {
+		cookie_text += "; Partitioned"
+	}
+	return cookie_text
+}
+
+// SetCookie adds a Set-Cookie header for c. Every cookie gets a header line
+// of its own: folding them into one line with commas, as is done for other
+// repeated fields, breaks on the comma inside Expires. Like DeclareTrailer it
+// must be called before the headers are written.
+func (w *Writer) SetCookie(c *Cookie) error {
+	if w.WriterState != STATUS_LINE && w.WriterState != HEADERS {
+		return errors.New("cant set a cookie after the " + WriterStateString(HEADERS) + " were written")
+	}
+	err := c.Valid()
+	if err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, c.String())
+	return nil
+}
+
+// validCookieDomain accepts a host name, optionally with the leading dot
+// older senders put in front of it, which recipients ignore.
+func validCookieDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, ".")
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			char := label[i]
+			if !(char >= 'a' && char <= 'z') && !(char >= 'A' && char <= 'Z') && !(char >= '0' && char <= '9') && char != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookie(t *testing.T) {
+	// Test: All attributes are serialized in order
+	cookie := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2030, time.January, 2, 15, 4, 5, 0, time.FixedZone("UTC+2", 2*60*60)),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SAME_SITE_NONE,
+		Partitioned: true,
+	}
+	require.NoError(t, cookie.Valid())
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Wed, 02 Jan 2030 13:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", cookie.String())
+
+	// Test: Negative MaxAge deletes the cookie
+	cookie = &Cookie{Name: "session", Value: "", MaxAge: -1, SameSite: SAME_SITE_LAX}
+	require.NoError(t, cookie.Valid())
+	assert.Equal(t, "session=; Max-Age=0; SameSite=Lax", cookie.String())
+
+	// Test: Quoted value is kept as is
+	cookie = &Cookie{Name: "quoted", Value: "\"v\""}
+	require.NoError(t, cookie.Valid())
+	assert.Equal(t, "quoted=\"v\"", cookie.String())
+
+	// Test: Invalid cookies are rejected
+	for _, invalid := range []*Cookie{
+		{Name: "", Value: "v"},
+		{Name: "bad name", Value: "v"},
+		{Name: "n", Value: "has space"},
+		{Name: "n", Value: "a;b"},
+		{Name: "n", Value: "a,b"},
+		{Name: "n", Value: "a\\b"},
+		{Name: "n", Value: "caf\xc3\xa9"},
+		{Name: "n", Value: "v", Path: "/a;b"},
+		{Name: "n", Value: "v", Path: "/a\r\n"},
+		{Name: "n", Value: "v", Domain: "exa mple.com"},
+		{Name: "n", Value: "v", Domain: "-example.com"},
+		{Name: "n", Value: "v", Domain: "example..com"},
+		{Name: "n", Value: "v", Expires: time.Date(1600, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "n", Value: "v", SameSite: SAME_SITE_NONE},
+		{Name: "n", Value: "v", Partitioned: true},
+		{Name: "n", Value: "v", SameSite: SameSite(7)},
+	} {
+		assert.Error(t, invalid.Valid(), invalid.String())
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	// Test: Every cookie gets its own Set-Cookie line
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	expires := time.Date(2030, time.January, 2, 15, 4, 5, 0, time.UTC)
+	require.NoError(t, w.SetCookie(&Cookie{Name: "a", Value: "1", Expires: expires}))
+	require.NoError(t, w.SetCookie(&Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	_, err := w.Write([]byte("ok"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "\r\nset-cookie: a=1; Expires=Wed, 02 Jan 2030 15:04:05 GMT\r\nset-cookie: b=2; HttpOnly\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nok"), out.String())
+
+	// Test: Low-level API writes them after the given headers
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.SetCookie(&Cookie{Name: "a", Value: "1"}))
+	require.NoError(t, w.WriteHeaders(map[string]string{"content-length": "0"}))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 0\r\nset-cookie: a=1\r\n\r\n", out.String())
+
+	// Test: Invalid cookie is not set
+	w = NewWriter(&bytes.Buffer{})
+	require.Error(t, w.SetCookie(&Cookie{Name: "a", Value: "x;y"}))
+	assert.Empty(t, w.cookies)
+
+	// Test: Cookie after the headers is an error
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(map[string]string{"content-length": "0"}))
+	require.Error(t, w.SetCookie(&Cookie{Name: "a", Value: "1"}))
+}
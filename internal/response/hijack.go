@@ -0,0 +1,55 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+)
+
+// EnableHijack lets a handler take the connection over with Hijack. buffered
+// are the bytes the server already read from conn past the request.
+func (w *Writer) EnableHijack(conn net.Conn, buffered []byte) {
+	w.conn = conn
+	w.conn_buffered = buffered
+}
+
+// OnHijack registers release to run right before the connection is handed
+// over. It must stop anything else reading from the connection and return
+// the bytes it read, which the new owner reads first.
+func (w *Writer) OnHijack(release func() []byte) {
+	w.release_conn = release
+}
+
+// Hijack hands the connection to the caller, who becomes responsible for
+// closing it; the server will neither write to it nor close it afterwards.
+// Anything the handler already wrote is flushed first. Bytes the client sent
+// after the request are returned in the reader of the ReadWriter, so read
+// from it rather than from the net.Conn directly.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.conn == nil {
+		return nil, nil, errors.New("cant hijack: connection is not available to this writer")
+	}
+	if w.hijacked {
+		return nil, nil, errors.New("cant hijack: connection was already hijacked")
+	}
+	if w.WriterState != STATUS_LINE && w.out != nil {
+		err := w.out.Flush()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	w.hijacked = true
+	if w.release_conn != nil {
+		w.conn_buffered = append(w.conn_buffered, w.release_conn()...)
+	}
+
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(w.conn_buffered), w.conn))
+	writer := bufio.NewWriter(w.conn)
+	return w.conn, bufio.NewReadWriter(reader, writer), nil
+}
+
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
@@ -0,0 +1,57 @@
+package response
+
+import (
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+)
+
+// EnableKeepAlive tells w that the connection may carry more requests after
+// this one, so the ResponseWriter API stops adding Connection: close.
+func (w *Writer) EnableKeepAlive() {
+	w.keep_alive_enabled = true
+}
+
+// KeepAliveEnabled reports whether EnableKeepAlive was called, i.e. whether a
+// response may leave the connection open.
+func (w *Writer) KeepAliveEnabled() bool {
+	return w.keep_alive_enabled
+}
+
+// KeepAlive reports whether the connection can be used for another request
+// once the response is finished: keep-alive was enabled, the response did
+// not say Connection: close and the client can tell where its body ends
+// without the connection closing.
+func (w *Writer) KeepAlive() bool {
+	return w.keep_alive && !w.hijacked
+}
+
+// bodyDelimited reports whether the body of a response with header h has a
+// known end: it is chunked, has a Content-Length or cannot have a body.
+func (w *Writer) bodyDelimited(h headers.Headers) bool {
+	if w.omit_body || bodyless(w.sent_status) {
+		return true
+	}
+	if _, ok := h.Get("Content-Length"); ok {
+		return true
+	}
+	transfer_encoding, _ := h.Get("Transfer-Encoding")
+	return strings.HasSuffix(strings.ToLower(strings.TrimSpace(transfer_encoding)), "chunked")
+}
+
+// bodyless reports whether a response with status_code never has a body
+// (1xx, 204 and 304), and so must not be framed with a Content-Length or
+// chunked encoding either (RFC 9110 section 8.6, RFC 9112 section 6.1).
+func bodyless(status_code StatusCode) bool {
+	return status_code < 200 || status_code == NO_CONTENT || status_code == NOT_MODIFIED
+}
+
+func closesConnection(h headers.Headers) bool {
+	connection, _ := h.Get("Connection")
+	for _, option := range strings.Split(connection, ",") {
+		if strings.EqualFold(strings.TrimSpace(option), "close") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+)
+
+// MAX_HEADER_BYTES limits the size of a response head (status line and
+// headers) and of a trailer section.
+const MAX_HEADER_BYTES = 1 << 20
+
+// Response is a response read by ResponseFromReader, the reading counterpart
+// of Writer.
+type Response struct {
+	HttpVersion string
+	StatusCode  StatusCode
+	// Status is the reason phrase the server sent, e.g. "Not Found".
+	Status  string
+	Headers headers.Headers
+	// ContentLength is the length of Body, or -1 if it is chunked or ends
+	// with the connection.
+	ContentLength int64
+	// Body streams the body from the reader it was parsed from; it has to
+	// be read to the end before the next response on that reader.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields of a chunked body, once Body was
+	// read to the end.
+	Trailers headers.Headers
+
+	close_delimited bool
+}
+
+func (r *Response) Get(header_name string) (header_value string, found bool) {
+	return r.Headers.Get(header_name)
+}
+
+// CloseDelimited reports whether the body ends when the connection closes,
+// which leaves nothing after it to read another response from.
+func (r *Response) CloseDelimited() bool {
+	return r.close_delimited
+}
+
+// ResponseFromReader reads the status line and headers of a response from
+// r and frames Body according to RFC 9112 section 6.3; request_method is
+// the method of the request it answers, since a response to HEAD has no body.
+// Pass a *bufio.Reader to read more responses from the same stream once Body
+// was read.
+func ResponseFromReader(r io.Reader, request_method string) (*Response, error) {
+	reader := bufio.NewReader(r) // r itself if it is a *bufio.Reader already
+	head_bytes := 0
+	status_line, err := readLine(reader, &head_bytes)
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{Headers: headers.Headers{}, Trailers: headers.Headers{}}
+	err = resp.parseStatusLine(status_line)
+	if err != nil {
+		return nil, err
+	}
+	err = readFields(reader, resp.Headers, &head_bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.ContentLength = -1
+	if !bodyAllowed(request_method, resp.StatusCode) {
+		resp.ContentLength = 0
+		resp.Body = io.NopCloser(strings.NewReader(""))
+		return resp, nil
+	}
+	if transfer_encoding, ok := resp.Get("Transfer-Encoding"); ok {
+		codings := strings.Split(transfer_encoding, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return nil, errors.New("response transfer coding is not supported: " + transfer_encoding)
+		}
+		resp.Body = io.NopCloser(&chunkedReader{reader: reader, trailers: resp.Trailers})
+		return resp, nil
+	}
+	if content_length, ok := resp.Get("Content-Length"); ok {
+		length, err := strconv.ParseInt(content_length, 10, 64)
+		if err != nil || length < 0 || !isDigits(content_length) {
+			return nil, errors.New("response Content-Length is not a valid length: " + content_length)
+		}
+		resp.ContentLength = length
+		resp.Body = io.NopCloser(&fixedReader{reader: reader, remaining: length})
+		return resp, nil
+	}
+	resp.close_delimited = true
+	resp.Body = io.NopCloser(reader)
+	return resp, nil
+}
+
+func (r *Response) parseStatusLine(line string) error {
+	version, rest, _ := strings.Cut(line, " ")
+	if version != "HTTP/1.1" && version != "HTTP/1.0" {
+		return errors.New("\"" + line + "\": status line must start with HTTP/1.1 or HTTP/1.0")
+	}
+	code, reason, found := strings.Cut(rest, " ")
+	if len(code) != 3 || !isDigits(code) || (!found && len(rest) != 3) {
+		return errors.New("\"" + line + "\": status line must have a 3 digit status code")
+	}
+	status_code, _ := strconv.Atoi(code)
+	r.HttpVersion = strings.TrimPrefix(version, "HTTP/")
+	r.StatusCode = StatusCode(status_code)
+	r.Status = reason
+	return nil
+}
+
+// bodyAllowed reports whether a response can have a body at all.
+func bodyAllowed(request_method string, status_code StatusCode) bool {
+	if request_method == "HEAD" {
+		return false
+	}
+	if status_code < 200 || status_code == NO_CONTENT || status_code == NOT_MODIFIED {
+		return false
+	}
+	return !(request_method == "CONNECT" && status_code < 300)
+}
+
+func isDigits(s string) bool {
+	for _, char := range s {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// readLine reads one CRLF terminated line and returns it without the CRLF.
+// head_bytes counts what was read against MAX_HEADER_BYTES.
+func readLine(reader *bufio.Reader, head_bytes *int) (string, error) {
+	line := ""
+	for {
+		fragment, err := reader.ReadSlice('\n')
+		line += string(fragment)
+		*head_bytes += len(fragment)
+		if *head_bytes > MAX_HEADER_BYTES {
+			return "", errors.New("response head is larger than " + strconv.Itoa(MAX_HEADER_BYTES) + " bytes")
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.New("line of the response must end with CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+// readFields reads field lines into h up to and including the empty line.
+func readFields(reader *bufio.Reader, h headers.Headers, head_bytes *int) error {
+	for {
+		line, err := readLine(reader, head_bytes)
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		_, done, err := h.Parse([]byte(line + "\r\n"))
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// fixedReader reads a body of a known length.
+type fixedReader struct {
+	reader    *bufio.Reader
+	remaining int64
+}
+
+func (fr *fixedReader) Read(p []byte) (int, error) {
+	if fr.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > fr.remaining {
+		p = p[:fr.remaining]
+	}
+	n, err := fr.reader.Read(p)
+	fr.remaining -= int64(n)
+	if err == io.EOF && fr.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	if fr.remaining == 0 {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// chunkedReader decodes a chunked body and fills trailers from the trailer
+// section that ends it.
+type chunkedReader struct {
+	reader    *bufio.Reader
+	trailers  headers.Headers
+	remaining int64 // of the current chunk
+	started   bool
+	done      bool
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+	if cr.remaining == 0 {
+		err := cr.nextChunk()
+		if err != nil {
+			return 0, err
+		}
+		if cr.done {
+			return 0, io.EOF
+		}
+	}
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.reader.Read(p)
+	cr.remaining -= int64(n)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextChunk finishes the current chunk and reads the size line of the next
+// one; for the last chunk it reads the trailer section.
+func (cr *chunkedReader) nextChunk() error {
+	line_bytes := 0
+	if cr.started {
+		line, err := readLine(cr.reader, &line_bytes)
+		if err != nil || line != "" {
+			return errors.New("chunk data must be followed by CRLF")
+		}
+	}
+	cr.started = true
+	line, err := readLine(cr.reader, &line_bytes)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	size, _, _ := strings.Cut(line, ";") // chunk extensions are ignored
+	size = strings.TrimRight(size, " \t")
+	chunk_size, err := strconv.ParseInt(size, 16, 64)
+	if err != nil || chunk_size < 0 || size == "" || strings.HasPrefix(size, "+") {
+		return errors.New("\"" + line + "\": chunk size must be a hex number")
+	}
+	if chunk_size == 0 {
+		cr.done = true
+		trailer_bytes := 0
+		return readFields(cr.reader, cr.trailers, &trailer_bytes)
+	}
+	cr.remaining = chunk_size
+	return nil
+}
@@ -0,0 +1,147 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, resp *Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return string(body)
+}
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Content-Length body written by the Writer
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(NOT_FOUND)
+	w.Write([]byte("no such page"))
+	require.NoError(t, w.Finish())
+	resp, err := ResponseFromReader(iotest.OneByteReader(out), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", resp.HttpVersion)
+	assert.Equal(t, NOT_FOUND, resp.StatusCode)
+	assert.Equal(t, "Not Found", resp.Status)
+	content_type, _ := resp.Get("Content-Type")
+	assert.Equal(t, "text/plain", content_type)
+	assert.Equal(t, int64(12), resp.ContentLength)
+	assert.False(t, resp.CloseDelimited())
+	assert.Equal(t, "no such page", readAll(t, resp))
+
+	// Test: Chunked body with trailers written by the Writer
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	w.Write([]byte(strings.Repeat("a", BODY_BUFFER_SIZE)))
+	w.Write([]byte("bc"))
+	w.Trailer().Set("X-Checksum", "abc123")
+	require.NoError(t, w.Finish())
+	resp, err = ResponseFromReader(iotest.OneByteReader(out), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, strings.Repeat("a", BODY_BUFFER_SIZE)+"bc", readAll(t, resp))
+	checksum, _ := resp.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc123", checksum)
+
+	// Test: Body delimited by the end of the connection
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.0 200 OK\r\nServer: old\r\n\r\nuntil the end"), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", resp.HttpVersion)
+	assert.True(t, resp.CloseDelimited())
+	assert.Equal(t, "until the end", readAll(t, resp))
+
+	// Test: Status line without a reason phrase
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 299\r\nContent-Length: 0\r\n\r\n"), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(299), resp.StatusCode)
+	assert.Equal(t, "", resp.Status)
+}
+
+func TestResponseFromReaderWithoutBody(t *testing.T) {
+	// Test: Responses that never have a body, one after the other on a stream
+	reader := bufio.NewReader(strings.NewReader("HTTP/1.1 100 Continue\r\n\r\n" +
+		"HTTP/1.1 204 No Content\r\nContent-Length: 5\r\n\r\n" +
+		"HTTP/1.1 304 Not Modified\r\nETag: \"x\"\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+	for _, status_code := range []StatusCode{CONTINUE, NO_CONTENT, NOT_MODIFIED} {
+		resp, err := ResponseFromReader(reader, "GET")
+		require.NoError(t, err)
+		assert.Equal(t, status_code, resp.StatusCode)
+		assert.Equal(t, int64(0), resp.ContentLength)
+		assert.Equal(t, "", readAll(t, resp))
+	}
+	resp, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readAll(t, resp))
+	_, err = ResponseFromReader(reader, "GET")
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Response to HEAD keeps its Content-Length but has no body
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.OmitBody()
+	w.Write([]byte("hello"))
+	require.NoError(t, w.Finish())
+	out.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	reader = bufio.NewReader(out)
+	resp, err = ResponseFromReader(reader, "HEAD")
+	require.NoError(t, err)
+	content_length, _ := resp.Get("Content-Length")
+	assert.Equal(t, "5", content_length)
+	assert.Equal(t, "", readAll(t, resp))
+	resp, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, OK, resp.StatusCode)
+
+	// Test: Successful response to CONNECT starts the tunnel
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\n\r\ntunnel bytes"), "CONNECT")
+	require.NoError(t, err)
+	assert.Equal(t, "", readAll(t, resp))
+}
+
+func TestResponseFromReaderErrors(t *testing.T) {
+	// Test: Malformed heads
+	for _, raw := range []string{
+		"HTTP/2 200 OK\r\n\r\n",
+		"HTTP/1.1 20 OK\r\n\r\n",
+		"HTTP/1.1 2000 OK\r\n\r\n",
+		"HTTP/1.1 abc OK\r\n\r\n",
+		"HTTP/1.1 200 OK\n\n",
+		"HTTP/1.1 200 OK\r\nBad Name: x\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: +5\r\n\r\nhello",
+		"HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip\r\n\r\n",
+	} {
+		_, err := ResponseFromReader(strings.NewReader(raw), "GET")
+		assert.Error(t, err, raw)
+	}
+
+	// Test: Head cut off
+	_, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-"), "GET")
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Test: Bodies cut off or badly framed
+	for _, raw := range []string{
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhiya\r\n0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-Trailer: 1\r\n",
+	} {
+		resp, err := ResponseFromReader(strings.NewReader(raw), "GET")
+		require.NoError(t, err, raw)
+		_, err = io.ReadAll(resp.Body)
+		assert.Error(t, err, raw)
+	}
+}
@@ -1,10 +1,11 @@
 package response
 
 import (
+	"bufio"
 	"errors"
-	"fmt"
 	"io"
 	"mime"
+	"net"
 	"strconv"
 	"strings"
 
@@ -14,11 +15,66 @@ import (
 type StatusCode int
 
 const (
-	OK           StatusCode = 200
-	CLIENT_ERROR StatusCode = 400
-	SERVER_ERROR StatusCode = 500
+	CONTINUE                        StatusCode = 100
+	SWITCHING_PROTOCOLS             StatusCode = 101
+	OK                              StatusCode = 200
+	NO_CONTENT                      StatusCode = 204
+	MOVED_PERMANENTLY               StatusCode = 301
+	FOUND                           StatusCode = 302
+	SEE_OTHER                       StatusCode = 303
+	NOT_MODIFIED                    StatusCode = 304
+	TEMPORARY_REDIRECT              StatusCode = 307
+	PERMANENT_REDIRECT              StatusCode = 308
+	CLIENT_ERROR                    StatusCode = 400
+	FORBIDDEN                       StatusCode = 403
+	NOT_FOUND                       StatusCode = 404
+	METHOD_NOT_ALLOWED              StatusCode = 405
+	NOT_ACCEPTABLE                  StatusCode = 406
+	CONTENT_TOO_LARGE               StatusCode = 413
+	UNSUPPORTED_MEDIA_TYPE          StatusCode = 415
+	UPGRADE_REQUIRED                StatusCode = 426
+	REQUEST_HEADER_FIELDS_TOO_LARGE StatusCode = 431
+	SERVER_ERROR                    StatusCode = 500
+	NOT_IMPLEMENTED                 StatusCode = 501
+	BAD_GATEWAY                     StatusCode = 502
+	SERVICE_UNAVAILABLE             StatusCode = 503
+	GATEWAY_TIMEOUT                 StatusCode = 504
+	HTTP_VERSION_NOT_SUPPORTED      StatusCode = 505
 )
 
+var statusText = map[StatusCode]string{
+	CONTINUE:                        "Continue",
+	SWITCHING_PROTOCOLS:             "Switching Protocols",
+	OK:                              "OK",
+	NO_CONTENT:                      "No Content",
+	MOVED_PERMANENTLY:               "Moved Permanently",
+	FOUND:                           "Found",
+	SEE_OTHER:                       "See Other",
+	NOT_MODIFIED:                    "Not Modified",
+	TEMPORARY_REDIRECT:              "Temporary Redirect",
+	PERMANENT_REDIRECT:              "Permanent Redirect",
+	CLIENT_ERROR:                    "Bad Request",
+	FORBIDDEN:                       "Forbidden",
+	NOT_FOUND:                       "Not Found",
+	METHOD_NOT_ALLOWED:              "Method Not Allowed",
+	NOT_ACCEPTABLE:                  "Not Acceptable",
+	CONTENT_TOO_LARGE:               "Content Too Large",
+	UNSUPPORTED_MEDIA_TYPE:          "Unsupported Media Type",
+	UPGRADE_REQUIRED:                "Upgrade Required",
+	REQUEST_HEADER_FIELDS_TOO_LARGE: "Request Header Fields Too Large",
+	SERVER_ERROR:                    "Internal Server Error",
+	NOT_IMPLEMENTED:                 "Not Implemented",
+	BAD_GATEWAY:                     "Bad Gateway",
+	SERVICE_UNAVAILABLE:             "Service Unavailable",
+	GATEWAY_TIMEOUT:                 "Gateway Timeout",
+	HTTP_VERSION_NOT_SUPPORTED:      "HTTP Version Not Supported",
+}
+
+// StatusText returns the reason phrase for status_code, or "" if unknown.
+func StatusText(status_code StatusCode) string {
+	return statusText[status_code]
+}
+
 type WriterState int
 
 const (
@@ -26,11 +82,56 @@ const (
 	HEADERS
 	BODY
 	TRAILERS
+	DONE
 )
 
 type Writer struct {
 	Writer      io.Writer
 	WriterState WriterState
+
+	out     *bufio.Writer
+	scratch [18]byte // room for a chunk size line
+
+	// state of the ResponseWriter API, see response_writer.go
+	header  headers.Headers
+	status  StatusCode
+	buffer  []byte
+	chunked bool
+	// run once, when the response ends, see OnFinish
+	on_finish []func()
+
+	// set by OmitBody for responses to HEAD requests
+	omit_body     bool
+	omitted_bytes int
+
+	// connection takeover, see hijack.go
+	conn          net.Conn
+	conn_buffered []byte
+	hijacked      bool
+	release_conn  func() []byte
+
+	// trailers, see trailers.go
+	trailer_names []string
+	trailer       headers.Headers
+
+	// Set-Cookie values, one header line each, see cookie.go
+	cookies []string
+
+	// persistent connections, see keep_alive.go
+	keep_alive_enabled bool
+	keep_alive         bool
+	sent_status        StatusCode
+}
+
+// OUTPUT_BUFFER_SIZE is the size of the buffer in front of the connection;
+// nothing reaches the client before it fills up or Flush is called.
+const OUTPUT_BUFFER_SIZE int = 4096
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		Writer:      w,
+		WriterState: STATUS_LINE,
+	}
 }
 
 func WriterStateString(ws WriterState) string {
@@ -44,12 +145,28 @@ func WriterStateString(ws WriterState) string {
 		write_state_string = "body"
 	case TRAILERS:
 		write_state_string = "trailers"
+	case DONE:
+		write_state_string = "nothing (response is complete)"
 	}
 
 	return write_state_string
 }
 
+// output is where every part of the response is written to; it buffers in
+// front of w.Writer until Flush.
+func (w *Writer) output() *bufio.Writer {
+	if w.out == nil {
+		w.out = bufio.NewWriterSize(w.Writer, OUTPUT_BUFFER_SIZE)
+	}
+	return w.out
+}
+
+// Close flushes what is still buffered and closes the underlying writer if it
+// can be closed.
 func (w *Writer) Close() error {
+	if w.out != nil {
+		w.out.Flush()
+	}
 	if closer, ok := w.Writer.(io.Closer); ok {
 		return closer.Close()
 	}
@@ -60,22 +177,12 @@ func (w *Writer) WriteStatusLine(status_code StatusCode) error {
 	if w.WriterState != STATUS_LINE {
 		return errors.New("cant write " + WriterStateString(STATUS_LINE) + " now, you should write: " + WriterStateString(w.WriterState))
 	}
-	status_line := "HTTP/1.1 " + strconv.Itoa(int(status_code)) + " "
-
-	switch status_code {
-	case OK:
-		status_line += "OK"
-	case CLIENT_ERROR:
-		status_line += "Bad Request"
-	case SERVER_ERROR:
-		status_line += "Internal Server Error"
-	}
+	status_line := "HTTP/1.1 " + strconv.Itoa(int(status_code)) + " " + StatusText(status_code) + "\r\n"
 
-	status_line += "\r\n"
-
-	_, err := w.Writer.Write([]byte(status_line))
+	_, err := w.output().WriteString(status_line)
 	if err == nil {
 		w.WriterState = HEADERS
+		w.sent_status = status_code
 	}
 	return err
 }
@@ -103,16 +210,30 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 		return errors.New("cant write " + WriterStateString(HEADERS) + " now, you should write: " + WriterStateString(w.WriterState))
 	}
 
+	if declared, ok := headers["trailer"]; ok && len(w.trailer_names) == 0 {
+		err := w.DeclareTrailer(strings.Split(declared, ",")...)
+		if err != nil {
+			return err
+		}
+	}
+
 	headers_text := ""
 
 	for key, value := range headers {
 		headers_text += key + ": " + value + "\r\n"
 	}
+	if _, ok := headers["trailer"]; !ok && len(w.trailer_names) > 0 {
+		headers_text += "trailer: " + strings.Join(w.trailer_names, ", ") + "\r\n"
+	}
+	for _, cookie := range w.cookies {
+		headers_text += "set-cookie: " + cookie + "\r\n"
+	}
 	headers_text += "\r\n"
 
-	_, err := w.Writer.Write([]byte(headers_text))
+	_, err := w.output().WriteString(headers_text)
 	if err == nil {
 		w.WriterState = BODY
+		w.keep_alive = w.keep_alive_enabled && w.bodyDelimited(headers) && !closesConnection(headers)
 	}
 	return err
 }
@@ -122,59 +243,79 @@ func (w *Writer) WriteBody(data []byte) (int, error) {
 		return 0, errors.New("cant write " + WriterStateString(BODY) + " now, you should write: " + WriterStateString(w.WriterState))
 	}
 
-	n, err := w.Writer.Write(data)
+	if w.omit_body {
+		w.WriterState = DONE
+		return len(data), nil
+	}
+	n, err := w.output().Write(data)
 	if err == nil {
-		w.WriterState = TRAILERS
+		w.WriterState = DONE // a body that is not chunked cannot carry trailers
 	}
 	return n, err
 }
 
+// WriteChunkedBody sends p as one chunk. The chunk is framed around p rather
+// than copied into a new string: small chunks are gathered in the output
+// buffer, large ones skip it and go to the connection as they are.
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.WriterState != BODY {
 		return 0, errors.New("cant write " + WriterStateString(BODY) + " now, you should write: " + WriterStateString(w.WriterState))
 	}
+	if len(p) == 0 {
+		return 0, nil // an empty chunk would terminate the body
+	}
+	if w.omit_body {
+		return len(p), nil
+	}
 
-	chunked_body := ""
-	chunk_length_in_hex := fmt.Sprintf("%X", len(p))
-	chunked_body += chunk_length_in_hex + "\r\n" + string(p) + "\r\n"
-
-	n, err := w.Writer.Write([]byte(chunked_body))
-	return n, err
+	out := w.output()
+	size_line := strconv.AppendUint(w.scratch[:0], uint64(len(p)), 16)
+	for i, char := range size_line {
+		if char >= 'a' && char <= 'f' {
+			size_line[i] = char - 'a' + 'A' // upper case hex digits, like %X
+		}
+	}
+	size_line = append(size_line, '\r', '\n')
+	_, err := out.Write(size_line)
+	if err != nil {
+		return 0, err
+	}
+	if len(p) >= out.Size() {
+		err = out.Flush()
+		if err != nil {
+			return 0, err
+		}
+		n, err := w.Writer.Write(p)
+		if err != nil {
+			return n, err
+		}
+	} else {
+		_, err = out.Write(p)
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = out.WriteString("\r\n")
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
+// WriteChunkedBodyDone sends the last (empty) chunk. The response is only
+// complete after the trailer section, see WriteTrailers.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if w.WriterState != BODY {
 		return 0, errors.New("cant write " + WriterStateString(BODY) + " now, you should write: " + WriterStateString(w.WriterState))
 	}
 
-	chunked_body_done := "0\r\n"
-
-	n, err := w.Writer.Write([]byte(chunked_body_done))
+	if w.omit_body {
+		w.WriterState = TRAILERS
+		return 0, nil
+	}
+	n, err := w.output().WriteString("0\r\n")
 	if err == nil {
 		w.WriterState = TRAILERS
 	}
 	return n, err
 }
-
-func (w *Writer) WriteTrailers(h headers.Headers) error {
-	if w.WriterState != TRAILERS {
-		return errors.New("cant write " + WriterStateString(TRAILERS) + " now, you should write: " + WriterStateString(w.WriterState))
-	}
-	trailers_string, ok := h["trailer"]
-	if !ok {
-		return errors.New("cant write " + WriterStateString(TRAILERS) + "; because \"Trailer\" is not specified in headers")
-	}
-
-	trailers := strings.Split(trailers_string, ", ")
-	trailers_text := ""
-	for _, trailer := range trailers {
-		trailers_text += trailer + ": " + h[strings.ToLower(trailer)] + "\r\n"
-	}
-	trailers_text += "\r\n"
-
-	_, err := w.Writer.Write([]byte(trailers_text))
-	if err != nil {
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,298 @@
+package response
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+)
+
+// BODY_BUFFER_SIZE is how much of a body Write keeps in memory before giving
+// up on sending a Content-Length and switching to chunked encoding.
+const BODY_BUFFER_SIZE int = 4096
+
+// ResponseWriter is the higher level way of answering a request: set headers
+// through Header(), optionally pick a status with WriteHeader and Write the
+// body. Nothing goes out on the wire until the body outgrows BODY_BUFFER_SIZE
+// or the handler returns, which is what lets the writer choose the framing:
+// a body that fits in the buffer is sent with a Content-Length, a larger one
+// (a stream) is sent chunked unless the handler set Content-Length itself.
+type ResponseWriter interface {
+	Header() headers.Headers
+	WriteHeader(status_code StatusCode)
+	Write(p []byte) (int, error)
+}
+
+var _ ResponseWriter = (*Writer)(nil)
+
+// Header returns the headers that will be sent with the response. Changing
+// them after the status line went out has no effect.
+func (w *Writer) Header() headers.Headers {
+	if w.header == nil {
+		w.header = headers.Headers{}
+	}
+	return w.header
+}
+
+// WriteHeader sets the status code of the response; only the first call
+// counts. Without it the status is 200 OK.
+func (w *Writer) WriteHeader(status_code StatusCode) {
+	if w.status != 0 || w.WriterState != STATUS_LINE {
+		return
+	}
+	w.status = status_code
+}
+
+// OmitBody turns w into the writer of a response to a HEAD request: status
+// line and headers are sent as they would be for GET, including the
+// Content-Length the body would have had, but no body bytes are. Handlers can
+// write the body as usual.
+func (w *Writer) OmitBody() {
+	w.omit_body = true
+}
+
+// Reset throws away everything a handler prepared that did not go out yet:
+// the status, headers, buffered body, cookies and declared trailers, so that
+// a different response can be written instead, e.g. an error. It does nothing
+// once the status line was sent.
+func (w *Writer) Reset() {
+	if w.WriterState != STATUS_LINE {
+		return
+	}
+	w.header = nil
+	w.status = 0
+	w.buffer = nil
+	w.omitted_bytes = 0
+	w.cookies = nil
+	w.trailer_names = nil
+	w.trailer = nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.omit_body && w.WriterState == STATUS_LINE {
+		// only the length of what is written matters
+		w.omitted_bytes += len(p)
+		return len(p), nil
+	}
+	if w.WriterState == STATUS_LINE {
+		w.buffer = append(w.buffer, p...)
+		if len(w.buffer) <= BODY_BUFFER_SIZE {
+			return len(p), nil
+		}
+		if err := w.commit(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return w.writeBodyPart(p)
+}
+
+func (w *Writer) writeBodyPart(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.omit_body {
+		return len(p), nil
+	}
+	if w.chunked {
+		n, err := w.WriteChunkedBody(p)
+		if err != nil {
+			return 0, err
+		}
+		if n < len(p) {
+			return n, nil
+		}
+		return len(p), nil
+	}
+	if w.WriterState != BODY {
+		return w.WriteBody(p)
+	}
+	return w.output().Write(p)
+}
+
+// writeHeader sends the status line and w.header, filling in what the
+// handler did not set.
+func (w *Writer) writeHeader() error {
+	if w.status == 0 {
+		w.status = OK
+	}
+	h := w.Header()
+	if _, ok := h.Get("Connection"); !ok && !w.keep_alive_enabled {
+		h.Set("Connection", "close")
+	}
+	err := w.WriteStatusLine(w.status)
+	if err != nil {
+		return err
+	}
+	return w.WriteHeaders(h)
+}
+
+// Flush sends everything written so far to the client. If the status line is
+// not out yet it is sent now; since the final length of the body cannot be
+// known at this point, the body is chunked unless the handler set a
+// Content-Length itself.
+func (w *Writer) Flush() error {
+	if w.WriterState == STATUS_LINE {
+		err := w.commit()
+		if err != nil {
+			return err
+		}
+	}
+	return w.output().Flush()
+}
+
+// commit sends the status line and the headers along with the buffered body,
+// choosing chunked encoding when no Content-Length was set.
+func (w *Writer) commit() error {
+	if w.status != 0 && bodyless(w.status) {
+		// whatever the handler wrote is dropped, as for a HEAD request
+		w.omit_body = true
+	} else if _, ok := w.Header().Get("Content-Length"); !ok {
+		w.Header().Set("Transfer-Encoding", "chunked")
+		w.chunked = true
+	}
+	buffered := w.buffer
+	w.buffer = nil
+	err := w.writeHeader()
+	if err != nil {
+		return err
+	}
+	_, err = w.writeBodyPart(buffered)
+	return err
+}
+
+// ReadFrom writes everything read from r as the body. When r is a regular
+// file and the handler did not pick a length, the file size becomes the
+// Content-Length; an identity body is then handed to the underlying writer's
+// own ReadFrom, which for a TCP connection lets the kernel copy the file with
+// sendfile/splice instead of passing it through user space.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.WriterState == STATUS_LINE && len(w.buffer) == 0 {
+		if _, ok := w.Header().Get("Content-Length"); !ok {
+			if size, ok := remainingFileSize(r); ok {
+				w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+			}
+		}
+	}
+	if w.WriterState == STATUS_LINE {
+		err := w.commit()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if w.WriterState != BODY {
+		return 0, errors.New("cant write " + WriterStateString(BODY) + " now, you should write: " + WriterStateString(w.WriterState))
+	}
+
+	if w.omit_body {
+		if size, ok := remainingFileSize(r); ok {
+			return size, nil
+		}
+		return io.Copy(io.Discard, r)
+	}
+
+	if !w.chunked {
+		err := w.output().Flush()
+		if err != nil {
+			return 0, err
+		}
+		return io.Copy(w.Writer, r)
+	}
+
+	var total int64
+	chunk := make([]byte, OUTPUT_BUFFER_SIZE)
+	for {
+		n, read_err := r.Read(chunk)
+		if n > 0 {
+			_, err := w.WriteChunkedBody(chunk[:n])
+			if err != nil {
+				return total, err
+			}
+			total += int64(n)
+		}
+		if read_err == io.EOF {
+			return total, nil
+		}
+		if read_err != nil {
+			return total, read_err
+		}
+	}
+}
+
+func remainingFileSize(r io.Reader) (int64, bool) {
+	file, ok := r.(*os.File)
+	if !ok {
+		return 0, false
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, false
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	return info.Size() - offset, true
+}
+
+// Finish completes the response once the handler returned: a response that
+// is still buffered is sent with a Content-Length (or chunked, when trailers
+// were declared), a chunked one gets its terminating chunk and trailer
+// section, and whatever is left in the output buffer is flushed.
+func (w *Writer) Finish() error {
+	w.runFinishHooks()
+	if w.hijacked {
+		return nil // the connection is not ours anymore
+	}
+	if w.WriterState == STATUS_LINE && !(w.status != 0 && bodyless(w.status)) {
+		h := w.Header()
+		if _, ok := h.Get("Content-Length"); !ok && len(w.trailer_names) == 0 {
+			h.Set("Content-Length", strconv.Itoa(len(w.buffer)+w.omitted_bytes))
+		}
+		if _, ok := h.Get("Content-Type"); !ok && len(w.buffer)+w.omitted_bytes > 0 {
+			h.Set("Content-Type", "text/plain")
+		}
+	}
+	if w.WriterState == STATUS_LINE {
+		err := w.commit()
+		if err != nil {
+			return err
+		}
+	}
+	if w.chunked && w.WriterState == BODY {
+		_, err := w.WriteChunkedBodyDone()
+		if err != nil {
+			return err
+		}
+	}
+	if w.WriterState == TRAILERS {
+		err := w.WriteTrailers(nil)
+		if err != nil {
+			return err
+		}
+	}
+	return w.output().Flush()
+}
+
+// OnFinish registers stop to run when the response ends, before Finish
+// writes anything or Abort gives up on it. Whatever still writes to w in the
+// background, like a heartbeat, must be stopped by it and waited for.
+func (w *Writer) OnFinish(stop func()) {
+	w.on_finish = append(w.on_finish, stop)
+}
+
+// Abort gives up on the response without completing it, for a connection
+// that is about to be reset. Only the OnFinish hooks run.
+func (w *Writer) Abort() {
+	w.runFinishHooks()
+}
+
+func (w *Writer) runFinishHooks() {
+	hooks := w.on_finish
+	w.on_finish = nil
+	for _, stop := range hooks {
+		stop()
+	}
+}
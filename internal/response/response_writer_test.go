@@ -0,0 +1,201 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseWriterFraming(t *testing.T) {
+	// Test: Small body is sent with a Content-Length
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.Header().Set("Content-Type", "text/html")
+	_, err := w.Write([]byte("<h1>hi</h1>"))
+	require.NoError(t, err)
+	assert.Equal(t, "", out.String())
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 200 OK\r\n"), out.String())
+	assert.Contains(t, out.String(), "content-length: 11\r\n")
+	assert.Contains(t, out.String(), "content-type: text/html\r\n")
+	assert.NotContains(t, out.String(), "transfer-encoding")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n<h1>hi</h1>"), out.String())
+
+	// Test: Status set with WriteHeader, empty body
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.WriteHeader(NOT_FOUND)
+	w.WriteHeader(OK)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 404 Not Found\r\n"), out.String())
+	assert.Contains(t, out.String(), "content-length: 0\r\n")
+
+	// Test: Body larger than the buffer is sent chunked
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	first := strings.Repeat("a", BODY_BUFFER_SIZE)
+	_, err = w.Write([]byte(first))
+	require.NoError(t, err)
+	assert.Equal(t, "", out.String())
+	_, err = w.Write([]byte("bc"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("def"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, out.String(), "content-length")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n1002\r\n"+first+"bc\r\n3\r\ndef\r\n0\r\n\r\n"), out.String())
+
+	// Test: Large body with a Content-Length set by the handler is not chunked
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	body := strings.Repeat("z", BODY_BUFFER_SIZE*2)
+	w.Header().Set("Content-Length", "8192")
+	_, err = w.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "content-length: 8192\r\n")
+	assert.NotContains(t, out.String(), "transfer-encoding")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"+body))
+
+	// Test: Low level writes are left alone by Finish
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(map[string]string{"content-length": "2"}))
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 2\r\n\r\nok", out.String())
+}
+
+func TestResponseWriterFlushAndReadFrom(t *testing.T) {
+	// Test: Flush commits the headers as chunked and sends what was written
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	_, err := w.Write([]byte("event"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Contains(t, out.String(), "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n5\r\nevent\r\n"), out.String())
+	_, err = w.Write([]byte("more"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(out.String(), "5\r\nevent\r\n4\r\nmore\r\n0\r\n\r\n"), out.String())
+
+	// Test: Chunks larger than the output buffer are framed without losing bytes
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(map[string]string{"transfer-encoding": "chunked"}))
+	big := strings.Repeat("x", OUTPUT_BUFFER_SIZE+10)
+	n, err := w.WriteChunkedBody([]byte(big))
+	require.NoError(t, err)
+	assert.Equal(t, len(big), n)
+	_, err = w.WriteChunkedBody([]byte("tail"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n100A\r\n"+big+"\r\n4\r\ntail\r\n"), out.String())
+
+	// Test: ReadFrom a file uses its size as Content-Length
+	file, err := os.CreateTemp(t.TempDir(), "body")
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = file.WriteString("0123456789")
+	require.NoError(t, err)
+	_, err = file.Seek(2, io.SeekStart)
+	require.NoError(t, err)
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	copied, err := w.ReadFrom(file)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), copied)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "content-length: 8\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n23456789"), out.String())
+
+	// Test: ReadFrom a stream of unknown length is chunked
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	_, err = w.ReadFrom(strings.NewReader("streamed"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "8\r\nstreamed\r\n0\r\n\r\n"), out.String())
+}
+
+func TestResponseWriterBodylessStatus(t *testing.T) {
+	for _, status_code := range []StatusCode{NO_CONTENT, NOT_MODIFIED} {
+		// Test: Finish adds no Content-Length
+		out := &bytes.Buffer{}
+		w := NewWriter(out)
+		w.WriteHeader(status_code)
+		require.NoError(t, w.Finish())
+		assert.NotContains(t, out.String(), "content-length")
+		assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"), out.String())
+
+		// Test: Flushing does not switch to chunked, and body bytes are dropped
+		out = &bytes.Buffer{}
+		w = NewWriter(out)
+		w.WriteHeader(status_code)
+		_, err := w.Write([]byte("dropped"))
+		require.NoError(t, err)
+		require.NoError(t, w.Flush())
+		_, err = w.Write([]byte("dropped too"))
+		require.NoError(t, err)
+		require.NoError(t, w.Finish())
+		assert.NotContains(t, out.String(), "transfer-encoding")
+		assert.NotContains(t, out.String(), "content-type")
+		assert.NotContains(t, out.String(), "dropped")
+		assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"), out.String())
+	}
+}
+
+func TestResponseWriterFinishHooks(t *testing.T) {
+	// Test: Hooks run once, before Finish writes
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	calls := 0
+	w.OnFinish(func() {
+		calls++
+		assert.Empty(t, out.String())
+	})
+	require.NoError(t, w.Finish())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, 1, calls)
+
+	// Test: Abort runs them without writing anything
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	w.OnFinish(func() { calls++ })
+	w.Abort()
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, out.String())
+}
+
+func TestResponseWriterReset(t *testing.T) {
+	// Test: What the handler prepared is not sent after a Reset
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.Header().Set("X-Handler", "yes")
+	w.WriteHeader(NOT_ACCEPTABLE)
+	require.NoError(t, w.SetCookie(&Cookie{Name: "session", Value: "abc"}))
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	_, err := w.Write([]byte("half done"))
+	require.NoError(t, err)
+	w.Reset()
+	_, err = w.Write([]byte("error"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 200 OK\r\n"), out.String())
+	assert.NotContains(t, out.String(), "x-handler")
+	assert.NotContains(t, out.String(), "set-cookie")
+	assert.NotContains(t, out.String(), "trailer")
+	assert.NotContains(t, out.String(), "half done")
+	assert.Contains(t, out.String(), "content-length: 5\r\n")
+}
@@ -0,0 +1,123 @@
+package response
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+)
+
+// Fields a sender must not put in a trailer section (RFC 9110 6.5.1): they
+// frame or route the message, modify the request, authenticate, control the
+// response or describe the content, and a recipient may already have acted on
+// the header section by the time trailers arrive.
+var forbiddenTrailers = map[string]struct{}{
+	"transfer-encoding":   {},
+	"content-length":      {},
+	"host":                {},
+	"cache-control":       {},
+	"expect":              {},
+	"max-forwards":        {},
+	"pragma":              {},
+	"range":               {},
+	"te":                  {},
+	"if-match":            {},
+	"if-none-match":       {},
+	"if-modified-since":   {},
+	"if-unmodified-since": {},
+	"if-range":            {},
+	"authorization":       {},
+	"proxy-authorization": {},
+	"www-authenticate":    {},
+	"proxy-authenticate":  {},
+	"cookie":              {},
+	"set-cookie":          {},
+	"age":                 {},
+	"date":                {},
+	"expires":             {},
+	"location":            {},
+	"retry-after":         {},
+	"vary":                {},
+	"warning":             {},
+	"content-encoding":    {},
+	"content-type":        {},
+	"content-range":       {},
+	"trailer":             {},
+}
+
+// DeclareTrailer announces the fields that will follow a chunked body; they
+// are listed in the Trailer header, so it must be called before the headers
+// are written. Their values are set later through Trailer().
+func (w *Writer) DeclareTrailer(names ...string) error {
+	if w.WriterState != STATUS_LINE && w.WriterState != HEADERS {
+		return errors.New("cant declare trailers after the " + WriterStateString(HEADERS) + " were written")
+	}
+	// all or nothing: a bad name leaves the earlier ones undeclared too
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if !headers.ValidFieldName(name) {
+			return errors.New("\"" + name + "\": trailer name is not a valid field-name")
+		}
+		if _, forbidden := forbiddenTrailers[strings.ToLower(name)]; forbidden {
+			return errors.New("\"" + name + "\": field is not allowed in a trailer section")
+		}
+	}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if !w.declaredTrailer(name) {
+			w.trailer_names = append(w.trailer_names, name)
+		}
+	}
+	return nil
+}
+
+func (w *Writer) declaredTrailer(name string) bool {
+	for _, declared := range w.trailer_names {
+		if strings.EqualFold(declared, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Trailer returns the trailer values to send after the body. Only fields
+// declared with DeclareTrailer are sent.
+func (w *Writer) Trailer() headers.Headers {
+	if w.trailer == nil {
+		w.trailer = headers.Headers{}
+	}
+	return w.trailer
+}
+
+// WriteTrailers ends a chunked body that was closed with WriteChunkedBodyDone:
+// it sends the declared trailers that have a value, taken from h (which may be
+// nil) and Trailer(), followed by the empty line that terminates the message.
+func (w *Writer) WriteTrailers(h headers.Headers) error {
+	if w.WriterState != TRAILERS {
+		return errors.New("cant write " + WriterStateString(TRAILERS) + " now, you should write: " + WriterStateString(w.WriterState))
+	}
+	for key, value := range h {
+		if w.declaredTrailer(key) {
+			w.Trailer().Set(key, value)
+		}
+	}
+
+	if w.omit_body {
+		w.WriterState = DONE
+		return nil
+	}
+
+	trailers_text := ""
+	for _, name := range w.trailer_names {
+		if value, ok := w.Trailer().Get(name); ok {
+			trailers_text += name + ": " + value + "\r\n"
+		}
+	}
+	trailers_text += "\r\n"
+
+	_, err := w.output().WriteString(trailers_text)
+	if err == nil {
+		w.WriterState = DONE
+	}
+	return err
+}
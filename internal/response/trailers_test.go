@@ -0,0 +1,90 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrailers(t *testing.T) {
+	// Test: Declared trailers are announced and sent after the last chunk
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	require.NoError(t, w.DeclareTrailer("X-Content-SHA256", "X-Content-Length"))
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(map[string]string{"transfer-encoding": "chunked"}))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	w.Trailer().Set("X-Content-SHA256", "abc")
+	w.Trailer().Set("X-Content-Length", "5")
+	w.Trailer().Set("X-Not-Declared", "dropped")
+	require.NoError(t, w.WriteTrailers(nil))
+	require.NoError(t, w.Flush())
+	assert.Contains(t, out.String(), "trailer: X-Content-SHA256, X-Content-Length\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "5\r\nhello\r\n0\r\nX-Content-SHA256: abc\r\nX-Content-Length: 5\r\n\r\n"), out.String())
+
+	// Test: Forbidden trailer fields are rejected
+	w = NewWriter(&bytes.Buffer{})
+	require.Error(t, w.DeclareTrailer("Content-Length"))
+	require.Error(t, w.DeclareTrailer("host"))
+	require.Error(t, w.DeclareTrailer("Transfer-Encoding"))
+	require.Error(t, w.DeclareTrailer("bad name"))
+	require.Error(t, w.DeclareTrailer("X-Ok", "Set-Cookie"))
+
+	// Test: A rejected declaration declares none of its names
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	require.Error(t, w.DeclareTrailer("X-A", "Content-Length"))
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"content-length": "0"}))
+	assert.NotContains(t, out.String(), "trailer")
+
+	// Test: Trailer header written by the handler is still validated
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.Error(t, w.WriteHeaders(map[string]string{"trailer": "Content-Type"}))
+
+	// Test: Declaring after the headers were written fails
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(map[string]string{}))
+	require.Error(t, w.DeclareTrailer("X-Late"))
+
+	// Test: Finish terminates a chunked body that has no trailers
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(map[string]string{"transfer-encoding": "chunked"}))
+	_, err = w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(out.String(), "2\r\nhi\r\n0\r\n\r\n"), out.String())
+
+	// Test: Trailers with the ResponseWriter API force chunked encoding
+	out = &bytes.Buffer{}
+	w = NewWriter(out)
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	_, err = w.Write([]byte("small"))
+	require.NoError(t, err)
+	w.Trailer().Set("X-Checksum", "42")
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, out.String(), "content-length")
+	assert.True(t, strings.HasSuffix(out.String(), "5\r\nsmall\r\n0\r\nX-Checksum: 42\r\n\r\n"), out.String())
+
+	// Test: Trailers cannot follow a body that is not chunked
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(map[string]string{"content-length": "2"}))
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	require.Error(t, w.WriteTrailers(nil))
+}
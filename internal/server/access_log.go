@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+type AccessLogFormat int
+
+const (
+	COMMON_LOG_FORMAT AccessLogFormat = iota
+	COMBINED_LOG_FORMAT
+	JSON_LOG_FORMAT
+)
+
+const CLF_TIME_LAYOUT = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogEntry holds everything we know about a served request once the
+// handler returned. Fields that are unknown (e.g. the request could not be
+// parsed) are left empty and rendered as "-".
+type AccessLogEntry struct {
+	Time        time.Time
+	RemoteAddr  string
+	Method      string
+	Target      string
+	HttpVersion string
+	Status      response.StatusCode
+	// BytesWritten is the size of the response body as sent, the status
+	// line and headers not included, like %b in the Common Log Format.
+	BytesWritten int64
+	Duration     time.Duration
+	Referer      string
+	UserAgent    string
+	RequestID    string
+}
+
+// AccessLogger writes one record per request through a *slog.Logger, so the
+// access log can be routed with the rest of the application's telemetry.
+// Every record carries the entry as structured attributes; for the Common and
+// Combined formats the record message is the ready-made log line.
+type AccessLogger struct {
+	Logger *slog.Logger
+	Format AccessLogFormat
+}
+
+// NewAccessLogger returns an AccessLogger that writes to out. Common and
+// Combined lines are written verbatim (one per line), JSON_LOG_FORMAT writes
+// JSON lines.
+func NewAccessLogger(out io.Writer, format AccessLogFormat) *AccessLogger {
+	var handler slog.Handler
+	if format == JSON_LOG_FORMAT {
+		handler = slog.NewJSONHandler(out, nil)
+	} else {
+		handler = &lineHandler{out: out}
+	}
+	return &AccessLogger{
+		Logger: slog.New(handler),
+		Format: format,
+	}
+}
+
+func (al *AccessLogger) Log(entry AccessLogEntry) {
+	if al == nil || al.Logger == nil {
+		return
+	}
+	message := "access"
+	switch al.Format {
+	case COMMON_LOG_FORMAT:
+		message = commonLogLine(entry)
+	case COMBINED_LOG_FORMAT:
+		message = combinedLogLine(entry)
+	}
+	al.Logger.LogAttrs(context.Background(), slog.LevelInfo, message,
+		slog.String("remote_addr", entry.RemoteAddr),
+		slog.String("method", entry.Method),
+		slog.String("target", entry.Target),
+		slog.String("http_version", entry.HttpVersion),
+		slog.Int("status", int(entry.Status)),
+		slog.Int64("bytes_written", entry.BytesWritten),
+		slog.Duration("duration", entry.Duration),
+		slog.String("referer", entry.Referer),
+		slog.String("user_agent", entry.UserAgent),
+		slog.String("request_id", entry.RequestID),
+	)
+}
+
+func commonLogLine(entry AccessLogEntry) string {
+	host, _, err := net.SplitHostPort(entry.RemoteAddr)
+	if err != nil {
+		host = entry.RemoteAddr
+	}
+	request_line := "-"
+	if entry.Method != "" {
+		request_line = entry.Method + " " + entry.Target + " HTTP/" + entry.HttpVersion
+	}
+	status := "-"
+	if entry.Status != 0 {
+		status = strconv.Itoa(int(entry.Status))
+	}
+	bytes_written := "-"
+	if entry.BytesWritten > 0 {
+		bytes_written = strconv.FormatInt(entry.BytesWritten, 10)
+	}
+	return orDash(host) + " - - [" + entry.Time.Format(CLF_TIME_LAYOUT) + "] " +
+		strconv.Quote(request_line) + " " + status + " " + bytes_written
+}
+
+func combinedLogLine(entry AccessLogEntry) string {
+	return commonLogLine(entry) + " " + strconv.Quote(orDash(entry.Referer)) + " " + strconv.Quote(orDash(entry.UserAgent))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// lineHandler is a slog.Handler that writes only the record message, which is
+// how Common/Combined lines are expected to look on disk.
+type lineHandler struct {
+	out io.Writer
+}
+
+func (lh *lineHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (lh *lineHandler) Handle(_ context.Context, record slog.Record) error {
+	_, err := io.WriteString(lh.out, record.Message+"\n")
+	return err
+}
+
+func (lh *lineHandler) WithAttrs(_ []slog.Attr) slog.Handler {
+	return lh
+}
+
+func (lh *lineHandler) WithGroup(_ string) slog.Handler {
+	return lh
+}
+
+// responseRecorder sits between the response.Writer and the connection and
+// remembers the status code and the number of bytes that went out, in total
+// and of the body alone.
+type responseRecorder struct {
+	writer        io.Writer
+	status        response.StatusCode
+	bytes_written int64
+	body_bytes    int64
+	// how much of the CRLFCRLF that ends the head was seen so far; 4 once
+	// the head is out
+	head_end int
+}
+
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	if rr.bytes_written == 0 && len(p) >= 12 && strings.HasPrefix(string(p[:9]), "HTTP/1.1 ") {
+		if status, err := strconv.Atoi(string(p[9:12])); err == nil {
+			rr.status = response.StatusCode(status)
+		}
+	}
+	n, err := rr.writer.Write(p)
+	rr.bytes_written += int64(n)
+	rr.countBody(p[:n])
+	return n, err
+}
+
+// countBody adds what follows the end of the head in p to body_bytes. The
+// head can end in a later write than the one it started in, or across two.
+func (rr *responseRecorder) countBody(p []byte) {
+	for i := 0; i < len(p) && rr.head_end < 4; i++ {
+		switch {
+		case p[i] == "\r\n\r\n"[rr.head_end]:
+			rr.head_end++
+		case p[i] == '\r':
+			rr.head_end = 1
+		default:
+			rr.head_end = 0
+		}
+		if rr.head_end == 4 {
+			rr.body_bytes += int64(len(p) - i - 1)
+			return
+		}
+	}
+	if rr.head_end == 4 {
+		rr.body_bytes += int64(len(p))
+	}
+}
+
+// ReadFrom keeps io.Copy from the response.Writer able to reach the
+// connection's own ReadFrom (sendfile/splice) through the recorder.
+func (rr *responseRecorder) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(rr.writer, r)
+	rr.bytes_written += n
+	rr.body_bytes += n // the head was flushed before
+	return n, err
+}
+
+func (rr *responseRecorder) Close() error {
+	if closer, ok := rr.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
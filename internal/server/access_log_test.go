@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	entry := AccessLogEntry{
+		Time:         time.Date(2024, time.March, 2, 10, 4, 5, 0, time.UTC),
+		RemoteAddr:   "127.0.0.1:51234",
+		Method:       "GET",
+		Target:       "/coffee",
+		HttpVersion:  "1.1",
+		Status:       200,
+		BytesWritten: 42,
+		Duration:     3 * time.Millisecond,
+		UserAgent:    "curl/7.81.0",
+		RequestID:    "abc123",
+	}
+
+	// Test: Common Log Format
+	out := &bytes.Buffer{}
+	NewAccessLogger(out, COMMON_LOG_FORMAT).Log(entry)
+	assert.Equal(t, "127.0.0.1 - - [02/Mar/2024:10:04:05 +0000] \"GET /coffee HTTP/1.1\" 200 42\n", out.String())
+
+	// Test: Combined Log Format
+	out = &bytes.Buffer{}
+	NewAccessLogger(out, COMBINED_LOG_FORMAT).Log(entry)
+	assert.Equal(t, "127.0.0.1 - - [02/Mar/2024:10:04:05 +0000] \"GET /coffee HTTP/1.1\" 200 42 \"-\" \"curl/7.81.0\"\n", out.String())
+
+	// Test: JSON lines
+	out = &bytes.Buffer{}
+	NewAccessLogger(out, JSON_LOG_FORMAT).Log(entry)
+	record := map[string]any{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Equal(t, "GET", record["method"])
+	assert.Equal(t, "/coffee", record["target"])
+	assert.Equal(t, float64(200), record["status"])
+	assert.Equal(t, float64(42), record["bytes_written"])
+	assert.Equal(t, "abc123", record["request_id"])
+	assert.Equal(t, "curl/7.81.0", record["user_agent"])
+
+	// Test: Unparsed request
+	out = &bytes.Buffer{}
+	NewAccessLogger(out, COMMON_LOG_FORMAT).Log(AccessLogEntry{Time: entry.Time, RemoteAddr: "[::1]:8080"})
+	assert.Equal(t, "::1 - - [02/Mar/2024:10:04:05 +0000] \"-\" - -\n", out.String())
+}
+
+func TestResponseRecorder(t *testing.T) {
+	// Test: Only the body counts as bytes of the response, even with the end of
+	// the head split across writes
+	out := &bytes.Buffer{}
+	recorder := &responseRecorder{writer: out}
+	for _, part := range []string{"HTTP/1.1 404 Not Found\r\n", "content-length: 9\r", "\n\r", "\nnot ", "found"} {
+		_, err := recorder.Write([]byte(part))
+		require.NoError(t, err)
+	}
+	_, err := recorder.ReadFrom(bytes.NewReader([]byte("!!")))
+	require.NoError(t, err)
+	assert.Equal(t, 404, int(recorder.status))
+	assert.Equal(t, int64(11), recorder.body_bytes)
+	assert.Equal(t, int64(out.Len()), recorder.bytes_written)
+
+	// Test: A head and body in a single write
+	recorder = &responseRecorder{writer: &bytes.Buffer{}}
+	_, err = recorder.Write([]byte("HTTP/1.1 200 OK\r\nconnection: close\r\n\r\nok"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), recorder.body_bytes)
+}
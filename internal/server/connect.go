@@ -0,0 +1,92 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+const CONNECT_DIAL_TIMEOUT = 10 * time.Second
+
+// NewConnectHandler returns a Handler that opens CONNECT tunnels: it dials
+// the host:port of the request target, answers 200 and then copies bytes
+// both ways until both sides are done. allow decides which targets may be
+// reached; nil allows every target, which makes the server an open proxy.
+func NewConnectHandler(allow func(host_port string) bool) Handler {
+	return func(w *response.Writer, r *request.Request) {
+		if r.RequestLine.Method != "CONNECT" {
+			w.Header().Set("Allow", "CONNECT")
+			w.WriteHeader(response.METHOD_NOT_ALLOWED)
+			return
+		}
+		target := r.RequestLine.RequestTarget
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			w.WriteHeader(response.CLIENT_ERROR)
+			w.Write([]byte("CONNECT target must be host:port"))
+			return
+		}
+		if allow != nil && !allow(target) {
+			w.WriteHeader(response.FORBIDDEN)
+			return
+		}
+
+		upstream, err := net.DialTimeout("tcp", target, CONNECT_DIAL_TIMEOUT)
+		if err != nil {
+			log.Println("Error while dialing CONNECT target \"" + target + "\": " + err.Error())
+			w.WriteHeader(response.BAD_GATEWAY)
+			return
+		}
+		defer upstream.Close()
+
+		// a 2xx answer to CONNECT has no body, so no framing headers either
+		err = w.WriteStatusLine(response.OK)
+		if err == nil {
+			err = w.WriteHeaders(headers.Headers{})
+		}
+		if err != nil {
+			log.Println("Error while answering CONNECT: " + err.Error())
+			return
+		}
+		client, client_rw, err := w.Hijack()
+		if err != nil {
+			log.Println("Error while hijacking connection: " + err.Error())
+			return
+		}
+		defer client.Close()
+
+		tunnel(client, client_rw.Reader, upstream)
+	}
+}
+
+// tunnel copies client -> upstream and upstream -> client. When one direction
+// ends, the write side of the other connection is closed, so half-closed
+// streams work; it returns once both directions ended.
+func tunnel(client net.Conn, client_reader io.Reader, upstream net.Conn) {
+	wait_group := sync.WaitGroup{}
+	wait_group.Add(2)
+	go func() {
+		defer wait_group.Done()
+		io.Copy(upstream, client_reader)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wait_group.Done()
+		io.Copy(client, upstream)
+		closeWrite(client)
+	}()
+	wait_group.Wait()
+}
+
+func closeWrite(conn net.Conn) {
+	if tcp_conn, ok := conn.(*net.TCPConn); ok {
+		tcp_conn.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer accepts connections and writes back everything it reads.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestConnectTunnel(t *testing.T) {
+	echo_address := startEchoServer(t)
+	router := NewRouter()
+	router.HandleConnect(NewConnectHandler(func(host_port string) bool {
+		return host_port == echo_address
+	}))
+	address := startServer(t, router.ServeRequest)
+
+	// Test: Tunnel carries bytes sent together with the request and after it
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("CONNECT " + echo_address + " HTTP/1.1\r\nHost: " + echo_address + "\r\n\r\nhello"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	status_line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status_line)
+	blank_line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank_line)
+	echoed := make([]byte, 5)
+	_, err = io.ReadFull(reader, echoed)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(echoed))
+	_, err = conn.Write([]byte(" world"))
+	require.NoError(t, err)
+	echoed = make([]byte, 6)
+	_, err = io.ReadFull(reader, echoed)
+	require.NoError(t, err)
+	assert.Equal(t, " world", string(echoed))
+
+	// Test: Half-close from the client ends the tunnel
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "", string(rest))
+
+	// Test: Target that is not allowed
+	reply, err := roundTrip(t, address, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 403 Forbidden\r\n"), reply)
+
+	// Test: Target without a port
+	reply, err = roundTrip(t, address, "CONNECT "+strings.Split(echo_address, ":")[0]+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 400 Bad Request\r\n"), reply)
+
+	// Test: Target that cannot be reached
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed_address := closed.Addr().String()
+	closed.Close()
+	router.HandleConnect(NewConnectHandler(nil))
+	reply, err = roundTrip(t, address, "CONNECT "+closed_address+" HTTP/1.1\r\nHost: "+closed_address+"\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 502 Bad Gateway\r\n"), reply)
+
+	// Test: Router without a CONNECT handler
+	address = startServer(t, NewRouter().ServeRequest)
+	reply, err = roundTrip(t, address, "CONNECT "+echo_address+" HTTP/1.1\r\nHost: "+echo_address+"\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 501 Not Implemented\r\n"), reply)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrClientDisconnected is the cause of a request context that was
+	// cancelled because the client closed the connection.
+	ErrClientDisconnected = errors.New("client disconnected")
+	// ErrServerClosed is the cause of request contexts cancelled by Close.
+	ErrServerClosed = errors.New("server closed")
+	// ErrRequestTimeout is the cause of a request context whose deadline
+	// (see WithRequestTimeout) passed.
+	ErrRequestTimeout = errors.New("request timed out")
+)
+
+// WATCH_BUFFER_SIZE caps what connWatcher keeps of bytes the client sends
+// while its request is handled; past it the watcher stops reading.
+const WATCH_BUFFER_SIZE = 4096
+
+// WithRequestTimeout gives every request context a deadline of timeout after
+// the request was read. Handlers are not interrupted, they are expected to
+// watch the context.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.RequestTimeout = timeout
+	}
+}
+
+// requestContext derives the context of a request from the server's.
+func (s *Server) requestContext() (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(s.base_ctx)
+	if s.RequestTimeout <= 0 {
+		return ctx, cancel
+	}
+	timeout_ctx, cancel_timeout := context.WithTimeoutCause(ctx, s.RequestTimeout, ErrRequestTimeout)
+	return timeout_ctx, func(cause error) {
+		cancel_timeout()
+		cancel(cause)
+	}
+}
+
+// connWatcher reads from a connection while its request is being handled, so
+// a client that hangs up is noticed even though nobody else reads: the read
+// then fails and the request context is cancelled. Bytes that do arrive are
+// kept for whoever reads next.
+type connWatcher struct {
+	conn net.Conn
+	// reader reads from conn; it is the connection's readCounter, so what
+	// arrives while watching is counted too
+	reader   io.Reader
+	cancel   context.CancelCauseFunc
+	stopping atomic.Bool
+	done     chan struct{}
+	buffered []byte
+}
+
+func watchConnection(conn net.Conn, reader io.Reader, cancel context.CancelCauseFunc) *connWatcher {
+	watcher := &connWatcher{
+		conn:   conn,
+		reader: reader,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go watcher.watch()
+	return watcher
+}
+
+func (cw *connWatcher) watch() {
+	defer close(cw.done)
+	buffer := make([]byte, WATCH_BUFFER_SIZE)
+	for len(cw.buffered) < WATCH_BUFFER_SIZE {
+		n, err := cw.reader.Read(buffer[:WATCH_BUFFER_SIZE-len(cw.buffered)])
+		cw.buffered = append(cw.buffered, buffer[:n]...)
+		if err != nil {
+			if !cw.stopping.Load() {
+				cw.cancel(ErrClientDisconnected)
+			}
+			return
+		}
+	}
+}
+
+// stop interrupts the pending read and returns what was read while watching.
+func (cw *connWatcher) stop() []byte {
+	if cw.stopping.Swap(true) {
+		<-cw.done
+		return nil
+	}
+	cw.conn.SetReadDeadline(time.Unix(1, 0))
+	<-cw.done
+	cw.conn.SetReadDeadline(time.Time{})
+	return cw.buffered
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForCause serves a handler that blocks until its request context is
+// done and reports the cause.
+func waitForCause(causes chan<- error) Handler {
+	return func(w *response.Writer, r *request.Request) {
+		select {
+		case <-r.Context().Done():
+			causes <- context.Cause(r.Context())
+		case <-time.After(5 * time.Second):
+			causes <- nil
+		}
+	}
+}
+
+func sendRequest(t *testing.T, address string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	return conn
+}
+
+func TestRequestContext(t *testing.T) {
+	causes := make(chan error, 1)
+
+	// Test: Client disconnect cancels the context
+	address := startServer(t, waitForCause(causes))
+	conn := sendRequest(t, address)
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	assert.ErrorIs(t, <-causes, ErrClientDisconnected)
+
+	// Test: Request deadline
+	address = startServer(t, waitForCause(causes), WithRequestTimeout(50*time.Millisecond))
+	sendRequest(t, address)
+	assert.ErrorIs(t, <-causes, ErrRequestTimeout)
+
+	// Test: Server shutdown cancels the context
+	server, err := Serve(0, waitForCause(causes))
+	require.NoError(t, err)
+	sendRequest(t, server.Listener.Addr().String())
+	time.Sleep(50 * time.Millisecond)
+	server.Close()
+	assert.ErrorIs(t, <-causes, ErrServerClosed)
+
+	// Test: Context of a request that was not served by a server
+	req := &request.Request{}
+	assert.NoError(t, req.Context().Err())
+}
@@ -38,24 +38,33 @@ func (hr *HandlerResponse) ClearHeaders() {
 	}
 }
 
-type Handler func(response.Writer, *request.Request)
+// Handler serves a single request. The server never calls it with a nil
+// request: requests that fail to parse are answered by the server itself.
+type Handler func(*response.Writer, *request.Request)
 
-func (hr *HandlerResponse) HandlerResponseWriter(w response.Writer) {
+func (hr *HandlerResponse) HandlerResponseWriter(w *response.Writer) {
 	err := w.WriteStatusLine(hr.StatusCode)
 	if err != nil {
 		log.Println(err.Error())
 		w.Close()
 		return
 	}
-	if hr.headers["content-type"] == "" {
-		hr.headers["content-type"] = "text/plain"
+	content_type := hr.GetHeaders()["content-type"]
+	if content_type == "" {
+		content_type = "text/plain"
 	}
-	headers, err := response.GetDefaultHeaders(len(hr.Message), hr.headers["content-type"])
+	headers, err := response.GetDefaultHeaders(len(hr.Message), content_type)
 	if err != nil {
 		log.Println(err.Error())
 		w.Close()
 		return
 	}
+	// the connection is only closed if the handler or the writer says so
+	if connection, ok := hr.GetHeaders().Get("Connection"); ok {
+		headers.Set("Connection", connection)
+	} else if w.KeepAliveEnabled() {
+		headers.Delete("Connection")
+	}
 	err = w.WriteHeaders(headers)
 	if err != nil {
 		log.Println(err.Error())
@@ -70,8 +79,16 @@ func (hr *HandlerResponse) HandlerResponseWriter(w response.Writer) {
 	}
 }
 
-func (hr *HandlerResponse) HandlerErrorResponse(w response.Writer, StatusCode response.StatusCode, message string) {
-	w.WriterState = response.STATUS_LINE
+func (hr *HandlerResponse) HandlerErrorResponse(w *response.Writer, StatusCode response.StatusCode, message string) {
+	if w.WriterState != response.STATUS_LINE {
+		// part of a response is already out, appending a second one would
+		// only corrupt it
+		log.Println("cant write error response \"" + message + "\": " + response.WriterStateString(w.WriterState) + " was already reached")
+		w.Close()
+		return
+	}
+	// nothing the handler set, like a cookie, belongs in the error response
+	w.Reset()
 	hr.StatusCode = StatusCode
 	hr.ClearHeaders()
 	hr.SetHeader("Content-Type", "text/plain")
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+// DEFAULT_MAX_JSON_SIZE limits the body DecodeJSON reads when it is given no
+// limit of its own.
+const DEFAULT_MAX_JSON_SIZE = 1 << 20
+
+// RENDER_OFFERS are the media types Render can produce, in the order it
+// prefers them.
+var RENDER_OFFERS = []string{"application/json", "text/plain", "text/html"}
+
+// RequestError is an error in what the client sent, with the status a
+// handler should answer it with. RenderError does that.
+type RequestError struct {
+	StatusCode response.StatusCode
+	Message    string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func newRequestError(status_code response.StatusCode, message string) error {
+	return &RequestError{StatusCode: status_code, Message: message}
+}
+
+// DecodeJSON decodes the JSON body of r into v. The body must be declared as
+// JSON (application/json, or a +json type), hold a single value no longer
+// than max_size bytes (DEFAULT_MAX_JSON_SIZE if max_size is 0) and have no
+// fields that v does not. Failures are *RequestError: 415 for the wrong
+// Content-Type, 413 for a body that is too large and 400 for anything else,
+// with the line and column where decoding stopped.
+func DecodeJSON(r *request.Request, v any, max_size int) error {
+	if max_size == 0 {
+		max_size = DEFAULT_MAX_JSON_SIZE
+	}
+	content_type, _ := r.Get("Content-Type")
+	media_type, _, err := mime.ParseMediaType(content_type)
+	if err != nil || (media_type != "application/json" && !strings.HasSuffix(media_type, "+json")) {
+		return newRequestError(response.UNSUPPORTED_MEDIA_TYPE, "Content-Type must be application/json, not \""+content_type+"\"")
+	}
+	if len(r.Body) > max_size {
+		return newRequestError(response.CONTENT_TOO_LARGE, "JSON body is larger than "+strconv.Itoa(max_size)+" bytes")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(r.Body))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(v)
+	if err == nil {
+		// anything but whitespace after the value is an error
+		_, err = decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		return newRequestError(response.CLIENT_ERROR, "JSON body must hold a single value, more follows the one ending at "+position(r.Body, decoder.InputOffset()))
+	}
+
+	var syntax_err *json.SyntaxError
+	var type_err *json.UnmarshalTypeError
+	var invalid_err *json.InvalidUnmarshalError
+	switch {
+	case errors.As(err, &syntax_err):
+		return newRequestError(response.CLIENT_ERROR, "JSON syntax error at "+position(r.Body, syntax_err.Offset)+": "+syntax_err.Error())
+	case errors.As(err, &type_err):
+		return newRequestError(response.CLIENT_ERROR, "JSON field \""+type_err.Field+"\" at "+position(r.Body, type_err.Offset)+" must be "+type_err.Type.String()+", not "+type_err.Value)
+	case errors.Is(err, io.EOF):
+		return newRequestError(response.CLIENT_ERROR, "JSON body is empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return newRequestError(response.CLIENT_ERROR, "JSON body ends in the middle of a value")
+	case errors.As(err, &invalid_err):
+		return err // v is not a pointer, a bug in the handler
+	}
+	// what is left is a field v does not have, for which the decoder has no
+	// error type, or an error of v's own UnmarshalJSON
+	return newRequestError(response.CLIENT_ERROR, "JSON body has "+strings.TrimPrefix(err.Error(), "json: ")+" at "+position(r.Body, decoder.InputOffset()))
+}
+
+// position returns the line and column, counted from 1, of the last byte the
+// decoder read when it stopped at offset, i.e. the byte it failed on.
+func position(body []byte, offset int64) string {
+	offset = min(max(offset, 1), int64(len(body)))
+	before := body[:offset-1]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return "line " + strconv.Itoa(line) + ", column " + strconv.Itoa(column)
+}
+
+// Render answers r with data in whichever of RENDER_OFFERS the client
+// prefers: data marshalled as JSON, as text or as text in an HTML page. The
+// text is data itself for a string, String() for a fmt.Stringer and indented
+// JSON otherwise. A client that accepts none of them gets 406 Not Acceptable
+// with the list of types it could have asked for.
+func Render(w *response.Writer, r *request.Request, status_code response.StatusCode, data any) error {
+	w.Header().Set("Vary", "Accept")
+	media_type := Negotiate(r, RENDER_OFFERS...)
+	if media_type == "" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(response.NOT_ACCEPTABLE)
+		_, err := w.Write([]byte("Not Acceptable, available: " + strings.Join(RENDER_OFFERS, ", ")))
+		return err
+	}
+
+	var body []byte
+	var err error
+	if media_type == "application/json" {
+		body, err = json.Marshal(data)
+	} else {
+		var text string
+		text, err = renderText(data)
+		body = []byte(text)
+		if media_type == "text/html" {
+			title := strconv.Itoa(int(status_code)) + " " + response.StatusText(status_code)
+			body = []byte("<html>\r\n\t<head>\r\n\t\t<title>" + html.EscapeString(title) + "</title>\r\n\t</head>\r\n\t<body>\r\n\t\t<pre>" +
+				html.EscapeString(text) + "</pre>\r\n\t</body>\r\n</html>\r\n")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", media_type+"; charset=utf-8")
+	w.WriteHeader(status_code)
+	_, err = w.Write(body)
+	return err
+}
+
+func renderText(data any) (string, error) {
+	switch value := data.(type) {
+	case string:
+		return value, nil
+	case fmt.Stringer:
+		return value.String(), nil
+	}
+	text, err := json.MarshalIndent(data, "", "  ")
+	return string(text), err
+}
+
+// RenderError renders err as {"error": message}. A *RequestError gets its
+// status code; any other error is answered with 500, without its message,
+// which may hold details the client should not see.
+func RenderError(w *response.Writer, r *request.Request, err error) error {
+	var request_err *RequestError
+	if !errors.As(err, &request_err) {
+		return Render(w, r, response.SERVER_ERROR, errorBody{Error: response.StatusText(response.SERVER_ERROR)})
+	}
+	return Render(w, r, request_err.StatusCode, errorBody{Error: request_err.Message})
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+func (e errorBody) String() string {
+	return e.Error
+}
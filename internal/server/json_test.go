@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type order struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+}
+
+// jsonRequest parses a POST with the given Content-Type and body.
+func jsonRequest(t *testing.T, content_type, body string) *request.Request {
+	t.Helper()
+	r, err := request.RequestFromReader(strings.NewReader("POST /orders HTTP/1.1\r\nHost: localhost\r\nContent-Type: " + content_type +
+		"\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+	require.NoError(t, err)
+	return r
+}
+
+func TestDecodeJSON(t *testing.T) {
+	// Test: Valid body is decoded
+	var decoded order
+	require.NoError(t, DecodeJSON(jsonRequest(t, "application/json; charset=utf-8", "{\"item\": \"coffee\", \"quantity\": 2}\n"), &decoded, 0))
+	assert.Equal(t, order{Item: "coffee", Quantity: 2}, decoded)
+	require.NoError(t, DecodeJSON(jsonRequest(t, "application/merge-patch+json", "{\"quantity\": 3}"), &decoded, 0))
+	assert.Equal(t, 3, decoded.Quantity)
+
+	for _, test := range []struct {
+		content_type string
+		body         string
+		max_size     int
+		status_code  response.StatusCode
+		message      string
+	}{
+		// Test: Wrong Content-Type is 415
+		{"text/plain", "{}", 0, response.UNSUPPORTED_MEDIA_TYPE, "Content-Type must be application/json"},
+		{"", "{}", 0, response.UNSUPPORTED_MEDIA_TYPE, "Content-Type must be application/json"},
+		// Test: Body over the limit is 413
+		{"application/json", "{\"item\": \"coffee\"}", 10, response.CONTENT_TOO_LARGE, "larger than 10 bytes"},
+		// Test: Syntax errors are 400 with their position
+		{"application/json", "{\n  \"item\": \"coffee\",\n  \"quantity\": 2,\n}", 0, response.CLIENT_ERROR, "JSON syntax error at line 4, column 1"},
+		{"application/json", "{\"item\": \"coffee\"", 0, response.CLIENT_ERROR, "ends in the middle of a value"},
+		{"application/json", "", 0, response.CLIENT_ERROR, "JSON body is empty"},
+		// Test: Unknown fields, wrong types and trailing data are 400
+		{"application/json", "{\"item\": \"coffee\",\n\"size\": \"large\"}", 0, response.CLIENT_ERROR, "unknown field \"size\" at line 2"},
+		{"application/json", "{\"quantity\": \"two\"}", 0, response.CLIENT_ERROR, "JSON field \"quantity\" at line 1, column 18 must be int, not string"},
+		{"application/json", "{\"item\": \"coffee\"} {\"item\": \"tea\"}", 0, response.CLIENT_ERROR, "must hold a single value"},
+	} {
+		err := DecodeJSON(jsonRequest(t, test.content_type, test.body), &order{}, test.max_size)
+		var request_err *RequestError
+		require.ErrorAs(t, err, &request_err, test.body)
+		assert.Equal(t, test.status_code, request_err.StatusCode, test.body)
+		assert.Contains(t, err.Error(), test.message)
+	}
+
+	// Test: Decoding into something that is not a pointer is the handler's
+	// error, not the client's
+	err := DecodeJSON(jsonRequest(t, "application/json", "{}"), order{}, 0)
+	require.Error(t, err)
+	var request_err *RequestError
+	assert.False(t, errors.As(err, &request_err))
+}
+
+func TestRender(t *testing.T) {
+	address := startServer(t, func(w *response.Writer, r *request.Request) {
+		var o order
+		err := DecodeJSON(r, &o, 0)
+		if err != nil {
+			RenderError(w, r, err)
+			return
+		}
+		Render(w, r, response.OK, o)
+	})
+	post := func(accept, content_type, body string) string {
+		reply, err := roundTrip(t, address, "POST /orders HTTP/1.1\r\nHost: localhost\r\nAccept: "+accept+"\r\nContent-Type: "+content_type+
+			"\r\nContent-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body)
+		require.NoError(t, err)
+		return reply
+	}
+
+	// Test: JSON for a client that prefers it
+	reply := post("application/json", "application/json", "{\"item\": \"tea\", \"quantity\": 1}")
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 200 OK\r\n"), reply)
+	assert.Contains(t, reply, "content-type: application/json; charset=utf-8\r\n")
+	assert.Contains(t, reply, "vary: Accept\r\n")
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\n{\"item\":\"tea\",\"quantity\":1}"), reply)
+
+	// Test: Indented JSON as text, and in an HTML page
+	reply = post("text/plain", "application/json", "{\"item\": \"tea\", \"quantity\": 1}")
+	assert.Contains(t, reply, "content-type: text/plain; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\n{\n  \"item\": \"tea\",\n  \"quantity\": 1\n}"), reply)
+	reply = post("text/html", "application/json", "{\"item\": \"<b>tea</b>\", \"quantity\": 1}")
+	assert.Contains(t, reply, "content-type: text/html; charset=utf-8\r\n")
+	assert.Contains(t, reply, "<title>200 OK</title>")
+	assert.Contains(t, reply, "&#34;item&#34;: &#34;\\u003cb\\u003etea\\u003c/b\\u003e&#34;")
+
+	// Test: Errors are rendered with their status
+	reply = post("application/json", "text/plain", "{}")
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 415 Unsupported Media Type\r\n"), reply)
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\n{\"error\":\"Content-Type must be application/json, not \\\"text/plain\\\"\"}"), reply)
+	reply = post("text/plain", "application/json", "{\"item\": }")
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 400 Bad Request\r\n"), reply)
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\nJSON syntax error at line 1, column 10: invalid character '}' looking for beginning of value"), reply)
+
+	// Test: Nothing acceptable is 406
+	reply = post("image/png", "application/json", "{}")
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 406 Not Acceptable\r\n"), reply)
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\nNot Acceptable, available: application/json, text/plain, text/html"), reply)
+
+	// Test: Other errors are a 500 without their message
+	address = startServer(t, func(w *response.Writer, r *request.Request) {
+		RenderError(w, r, strconv.ErrRange)
+	})
+	reply, err := roundTrip(t, address, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 500 Internal Server Error\r\n"), reply)
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\n{\"error\":\"Internal Server Error\"}"), reply)
+}
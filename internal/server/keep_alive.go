@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+)
+
+// readRequest waits for the next request on c, at most IdleTimeout for its
+// first byte and only until the server is closed, and reads it. start is when
+// the request began to arrive. If no byte of a request arrived the error is
+// io.EOF, whether the client closed the connection or the wait was cut short.
+func (s *Server) readRequest(c *connection) (req *request.Request, start time.Time, err error) {
+	c.counter.waiting = len(c.reader.Buffered()) == 0
+	c.counter.request_start = time.Now()
+	if c.counter.waiting && s.IdleTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+	}
+	stop_interrupt := context.AfterFunc(s.base_ctx, func() {
+		c.conn.SetReadDeadline(time.Unix(1, 0))
+	})
+	req, err = c.reader.ReadRequest()
+	stop_interrupt()
+	c.conn.SetReadDeadline(time.Time{})
+
+	if err != nil && (c.counter.waiting || errors.Is(err, os.ErrDeadlineExceeded)) {
+		err = io.EOF
+	}
+	c.counter.waiting = false
+	return req, c.counter.request_start, err
+}
+
+func hasToken(h headers.Headers, name, token string) bool {
+	value, _ := h.Get(name)
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
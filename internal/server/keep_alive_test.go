@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readResponse reads one response from reader and returns its body.
+func readResponse(t *testing.T, reader *bufio.Reader, method string) (*response.Response, string) {
+	t.Helper()
+	resp, err := response.ResponseFromReader(reader, method)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestKeepAlive(t *testing.T) {
+	address := startServer(t, func(w *response.Writer, r *request.Request) {
+		if r.RequestLine.RequestTarget == "/slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if r.RequestLine.RequestTarget == "/low-level" {
+			body := "low"
+			w.WriteStatusLine(response.OK)
+			h, _ := response.GetDefaultHeaders(len(body), "text/plain")
+			w.WriteHeaders(h)
+			w.WriteBody([]byte(body))
+			return
+		}
+		w.Write([]byte(r.RequestLine.Method + " " + r.RequestLine.RequestTarget + " " + string(r.Body)))
+	})
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// Test: Requests one after the other on the same connection
+	for _, target := range []string{"/one", "/two"} {
+		_, err = conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		resp, body := readResponse(t, reader, "GET")
+		assert.Equal(t, "GET "+target+" ", body)
+		_, closes := resp.Get("Connection")
+		assert.False(t, closes)
+	}
+
+	// Test: Pipelined requests are answered in order, the slow one included
+	_, err = conn.Write([]byte("POST /slow HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nfirst" +
+		"HEAD /head HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /third HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nthird"))
+	require.NoError(t, err)
+	_, body := readResponse(t, reader, "POST")
+	assert.Equal(t, "POST /slow first", body)
+	resp, body := readResponse(t, reader, "HEAD")
+	assert.Equal(t, "", body)
+	content_length, _ := resp.Get("Content-Length")
+	assert.Equal(t, "11", content_length)
+	_, body = readResponse(t, reader, "POST")
+	assert.Equal(t, "POST /third third", body)
+
+	// Test: Response that says Connection: close ends the connection
+	_, err = conn.Write([]byte("GET /low-level HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, body = readResponse(t, reader, "GET")
+	assert.Equal(t, "low", body)
+	_, err = reader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Client asking for Connection: close
+	reply, err := roundTripOpen(t, address, "GET /bye HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	assert.Contains(t, reply, "connection: close\r\n")
+}
+
+func TestIdleTimeout(t *testing.T) {
+	server, err := Serve(0, func(w *response.Writer, r *request.Request) {
+		w.Write([]byte("ok"))
+	}, WithIdleTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	address := server.Listener.Addr().String()
+
+	// Test: Connection waiting for its next request is closed after the idle timeout
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, body := readResponse(t, reader, "GET")
+	assert.Equal(t, "ok", body)
+	_, err = reader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Idle connection is closed with the server
+	server, err = Serve(0, func(w *response.Writer, r *request.Request) {
+		w.Write([]byte("ok"))
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	conn, err = net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	readResponse(t, reader, "GET")
+	server.Close()
+	_, err = reader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestKeepAliveHandlerResponse(t *testing.T) {
+	address := startServer(t, func(w *response.Writer, r *request.Request) {
+		handler_response := HandlerResponse{StatusCode: response.OK, Message: "answer to " + r.RequestLine.RequestTarget}
+		handler_response.HandlerResponseWriter(w)
+	})
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// Test: Pipelined requests to a HandlerResponse handler are all answered
+	_, err = conn.Write([]byte("GET /first HTTP/1.1\r\nHost: localhost\r\n\r\nGET /second HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	resp, body := readResponse(t, reader, "GET")
+	assert.Equal(t, "answer to /first", body)
+	_, closes := resp.Get("Connection")
+	assert.False(t, closes)
+	_, body = readResponse(t, reader, "GET")
+	assert.Equal(t, "answer to /second", body)
+
+	// Test: Connection: close is still sent when the client asks for it
+	reply, err := roundTripOpen(t, address, "GET /bye HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	assert.Contains(t, reply, "connection: close\r\n")
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/metrics"
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+// Metrics groups the statistics a Server keeps about the traffic it serves.
+// All of them live in Registry, which MetricsHandler exposes.
+type Metrics struct {
+	Registry           *metrics.Registry
+	requests_total     *metrics.Counter
+	request_duration   *metrics.Histogram
+	bytes_received     *metrics.Counter
+	bytes_sent         *metrics.Counter
+	active_connections *metrics.Gauge
+	parse_errors       *metrics.Counter
+}
+
+func NewMetrics() *Metrics {
+	registry := metrics.NewRegistry()
+	return &Metrics{
+		Registry:           registry,
+		requests_total:     registry.NewCounter("httpfromtcp_requests_total", "Total number of HTTP requests served.", "method", "status"),
+		request_duration:   registry.NewHistogram("httpfromtcp_request_duration_seconds", "Time spent serving a request, from accepting it to the handler returning.", nil, "method"),
+		bytes_received:     registry.NewCounter("httpfromtcp_received_bytes_total", "Total bytes read from client connections."),
+		bytes_sent:         registry.NewCounter("httpfromtcp_sent_bytes_total", "Total bytes written to client connections."),
+		active_connections: registry.NewGauge("httpfromtcp_active_connections", "Number of client connections currently open."),
+		parse_errors:       registry.NewCounter("httpfromtcp_parse_errors_total", "Requests that could not be parsed, by error type.", "type"),
+	}
+}
+
+// WithMetrics makes the server record its statistics into m.
+func WithMetrics(m *Metrics) Option {
+	return func(s *Server) {
+		s.Metrics = m
+	}
+}
+
+// MetricsHandler returns a Handler serving m in the Prometheus text
+// exposition format.
+func MetricsHandler(m *Metrics) Handler {
+	return func(w *response.Writer, r *request.Request) {
+		handler_response := HandlerResponse{}
+		text := strings.Builder{}
+		_, err := m.Registry.WriteTo(&text)
+		if err != nil {
+			handler_response.HandlerErrorResponse(w, response.SERVER_ERROR, err.Error())
+			return
+		}
+		handler_response.StatusCode = response.OK
+		handler_response.SetHeader("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		handler_response.Message = text.String()
+		handler_response.HandlerResponseWriter(w)
+	}
+}
+
+func (m *Metrics) connectionOpened() {
+	if m == nil {
+		return
+	}
+	m.active_connections.Inc()
+}
+
+func (m *Metrics) connectionClosed() {
+	if m == nil {
+		return
+	}
+	m.active_connections.Dec()
+}
+
+// transferred adds traffic to the byte counters. It is called once per
+// request, so a keep-alive connection is counted while it is still open.
+func (m *Metrics) transferred(bytes_received, bytes_sent int64) {
+	if m == nil {
+		return
+	}
+	m.bytes_received.Add(float64(bytes_received))
+	m.bytes_sent.Add(float64(bytes_sent))
+}
+
+func (m *Metrics) observeRequest(req *request.Request, status response.StatusCode, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	method := "UNKNOWN"
+	if req != nil {
+		method = req.RequestLine.Method
+	}
+	m.requests_total.Inc(method, strconv.Itoa(int(status)))
+	m.request_duration.Observe(duration.Seconds(), method)
+}
+
+func (m *Metrics) parseError(err error) {
+	if m == nil {
+		return
+	}
+	m.parse_errors.Inc(parseErrorType(err))
+}
+
+func parseErrorType(err error) string {
+	var parse_err *request.ParseError
+	var net_err net.Error
+	switch {
+	case errors.As(err, &parse_err):
+		return parse_err.Kind.String()
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.As(err, &net_err) && net_err.Timeout():
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+// readCounter counts the bytes read from the connection.
+type readCounter struct {
+	reader     io.Reader
+	bytes_read int64
+	eof        bool // the client closed its sending side
+	// waiting is set while nothing of the next request arrived; the first
+	// byte that does lifts the idle deadline and sets request_start
+	waiting       bool
+	request_start time.Time
+}
+
+func (rc *readCounter) Read(p []byte) (int, error) {
+	n, err := rc.reader.Read(p)
+	rc.bytes_read += int64(n)
+	if n > 0 && rc.waiting {
+		rc.waiting = false
+		rc.request_start = time.Now()
+		if conn, ok := rc.reader.(net.Conn); ok {
+			conn.SetReadDeadline(time.Time{})
+		}
+	}
+	if err == io.EOF {
+		rc.eof = true
+	}
+	return n, err
+}
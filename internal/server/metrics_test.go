@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsByteCounters(t *testing.T) {
+	m := NewMetrics()
+	address := startServer(t, func(w *response.Writer, r *request.Request) {
+		if r.RequestLine.RequestTarget == "/slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	}, WithMetrics(m))
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// Test: Bytes are counted per request while the connection stays open
+	first := "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	_, err = conn.Write([]byte(first))
+	require.NoError(t, err)
+	readResponse(t, reader, "GET")
+	require.Eventually(t, func() bool { return m.bytes_received.Value() == float64(len(first)) }, time.Second, time.Millisecond)
+	sent := m.bytes_sent.Value()
+	assert.Greater(t, sent, float64(0))
+
+	// Test: Bytes the connection watcher reads during a request are counted
+	slow := "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	next := "GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	_, err = conn.Write([]byte(slow))
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = conn.Write([]byte(next))
+	require.NoError(t, err)
+	readResponse(t, reader, "GET")
+	readResponse(t, reader, "GET")
+	require.Eventually(t, func() bool {
+		// the responses are the same size
+		return m.bytes_received.Value() == float64(len(first)+len(slow)+len(next)) && m.bytes_sent.Value() == 3*sent
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, float64(1), m.active_connections.Value())
+}
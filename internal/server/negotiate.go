@@ -0,0 +1,106 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+)
+
+// mediaRange is one element of an Accept header.
+type mediaRange struct {
+	media_type string
+	subtype    string
+	// weight in thousandths, 0 to 1000
+	quality int
+}
+
+// Negotiate returns the media type out of offers that the Accept header of r
+// prefers (RFC 9110 section 12.5.1), or "" if it accepts none of them. Each
+// offer gets the quality of the most specific range that matches it; ties go
+// to the offer listed first, as does a request without an Accept header.
+func Negotiate(r *request.Request, offers ...string) string {
+	accept, ok := r.Get("Accept")
+	if !ok || strings.TrimSpace(accept) == "" {
+		if len(offers) == 0 {
+			return ""
+		}
+		return offers[0]
+	}
+	ranges := parseAccept(accept)
+
+	best, best_quality := "", 0
+	for _, offer := range offers {
+		media_type, subtype, _ := strings.Cut(strings.ToLower(offer), "/")
+		quality, specificity := 0, -1
+		for _, accepted := range ranges {
+			matched := -1
+			switch {
+			case accepted.media_type == media_type && accepted.subtype == subtype:
+				matched = 2
+			case accepted.media_type == media_type && accepted.subtype == "*":
+				matched = 1
+			case accepted.media_type == "*" && accepted.subtype == "*":
+				matched = 0
+			}
+			if matched > specificity {
+				quality, specificity = accepted.quality, matched
+			}
+		}
+		if quality > best_quality {
+			best, best_quality = offer, quality
+		}
+	}
+	return best
+}
+
+// parseAccept parses the media ranges of an Accept header. Ranges that do not
+// parse, or have a quality that does not, are left out; parameters other
+// than the weight are ignored.
+func parseAccept(accept string) []mediaRange {
+	ranges := []mediaRange{}
+	for _, element := range strings.Split(accept, ",") {
+		params := strings.Split(element, ";")
+		media_type, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !ok || media_type == "" || subtype == "" || (media_type == "*" && subtype != "*") {
+			continue
+		}
+		accepted := mediaRange{media_type: media_type, subtype: subtype, quality: 1000}
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				accepted.quality, ok = parseQuality(strings.TrimSpace(value))
+				break
+			}
+		}
+		if ok {
+			ranges = append(ranges, accepted)
+		}
+	}
+	return ranges
+}
+
+// parseQuality parses a qvalue, "0" or "1" with up to three decimals, into
+// thousandths.
+func parseQuality(value string) (int, bool) {
+	whole, decimals, _ := strings.Cut(value, ".")
+	if (whole != "0" && whole != "1") || len(decimals) > 3 {
+		return 0, false
+	}
+	quality := 0
+	for i := 0; i < 3; i++ {
+		quality *= 10
+		if i < len(decimals) {
+			if decimals[i] < '0' || decimals[i] > '9' {
+				return 0, false
+			}
+			quality += int(decimals[i] - '0')
+		}
+	}
+	if whole == "1" {
+		if quality != 0 {
+			return 0, false
+		}
+		return 1000, true
+	}
+	return quality, true
+}
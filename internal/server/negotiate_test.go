@@ -0,0 +1,72 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acceptRequest parses a GET with the given Accept header, or none if accept
+// is "-".
+func acceptRequest(t *testing.T, accept string) *request.Request {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	if accept != "-" {
+		raw += "Accept: " + accept + "\r\n"
+	}
+	r, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	return r
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "text/plain", "text/html"}
+	for _, test := range []struct {
+		accept   string
+		expected string
+	}{
+		// Test: No Accept header, or an empty one, takes the first offer
+		{"-", "application/json"},
+		{"", "application/json"},
+		// Test: Exact matches and wildcards
+		{"text/html", "text/html"},
+		{"TEXT/Plain", "text/plain"},
+		{"text/*", "text/plain"},
+		{"*/*", "application/json"},
+		// Test: Highest quality wins, ties go to the first offer
+		{"text/html;q=0.9, application/json;q=0.8", "text/html"},
+		{"text/html, text/plain", "text/plain"},
+		{"text/html;level=1;q=0.5, text/plain;q=0.4", "text/html"},
+		{"text/*;q=0.3, text/html;q=0.7, */*;q=0.1", "text/html"},
+		// Test: The most specific range decides, even with a lower quality
+		{"text/*, text/plain;q=0.2", "text/html"},
+		{"*/*, application/json;q=0", "text/plain"},
+		// Test: Browser Accept header
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "text/html"},
+		// Test: Nothing acceptable
+		{"image/png", ""},
+		{"text/*;q=0, application/json;q=0.000", ""},
+		// Test: Ranges that do not parse are ignored
+		{"text/html;q=2, text/plain;q=0.5", "text/plain"},
+		{"text/html;q=0.5555, text/plain;q=abc, application/json;q=0.1", "application/json"},
+		{"*/html, text, /plain, text/plain;q=1.000", "text/plain"},
+	} {
+		assert.Equal(t, test.expected, Negotiate(acceptRequest(t, test.accept), offers...), test.accept)
+	}
+	assert.Equal(t, "", Negotiate(acceptRequest(t, "-")))
+}
+
+func TestParseQuality(t *testing.T) {
+	for value, expected := range map[string]int{"0": 0, "1": 1000, "1.": 1000, "1.000": 1000, "0.5": 500, "0.05": 50, "0.001": 1} {
+		quality, ok := parseQuality(value)
+		assert.True(t, ok, value)
+		assert.Equal(t, expected, quality, value)
+	}
+	for _, value := range []string{"", "2", "1.5", "0.1234", ".5", "-0.5", "0.a", "01"} {
+		_, ok := parseQuality(value)
+		assert.False(t, ok, value)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"log"
+	"net"
+	"runtime/debug"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+// recoverPanic keeps a panicking handler from taking the whole process down.
+// It must be handed the result of recover() from a deferred call in
+// serveRequest. If the status line was not written yet the client gets a 500,
+// otherwise the response is already half sent and the only honest thing left
+// is to abort the connection.
+func (s *Server) recoverPanic(recovered any, conn net.Conn, req *request.Request, w *response.Writer) {
+	if recovered == nil {
+		return
+	}
+
+	request_context := conn.RemoteAddr().String()
+	if req != nil {
+		request_context += " \"" + req.RequestLine.Method + " " + req.RequestLine.RequestTarget + "\""
+	}
+	log.Printf("panic serving %s: %v\n%s", request_context, recovered, debug.Stack())
+
+	if w.WriterState == response.STATUS_LINE {
+		handler_response := &HandlerResponse{}
+		handler_response.HandlerErrorResponse(w, response.SERVER_ERROR, "Internal Server Error")
+		w.Finish()
+		return
+	}
+	abortConnection(conn)
+	// after the reset, so a write blocked on the connection cannot hold it up
+	w.Abort()
+}
+
+// abortConnection closes conn with a TCP reset instead of a FIN, so the client
+// sees an error rather than a (truncated) response that looks complete.
+func abortConnection(conn net.Conn) {
+	if tcp_conn, ok := conn.(*net.TCPConn); ok {
+		tcp_conn.SetLinger(0)
+	}
+	conn.Close()
+}
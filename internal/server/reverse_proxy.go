@@ -0,0 +1,366 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/client"
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+// PROXY_BUFFER_SIZE is how much of an upstream body is read before it is
+// passed on to the client.
+const PROXY_BUFFER_SIZE = 32 * 1024
+
+// DEFAULT_HEALTH_CHECK_TIMEOUT is how long a health check waits for an
+// upstream to answer, unless HealthCheckTimeout says otherwise.
+const DEFAULT_HEALTH_CHECK_TIMEOUT = 2 * time.Second
+
+// hopByHopHeaders only concern a single connection and are not forwarded
+// (RFC 9110 section 7.6.1), along with every header the Connection header
+// names.
+var hopByHopHeaders = []string{
+	"connection",
+	"keep-alive",
+	"proxy-connection",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// Upstream is a server requests can be forwarded to.
+type Upstream struct {
+	URL     *url.URL
+	healthy atomic.Bool
+}
+
+func (u *Upstream) Healthy() bool {
+	return u.healthy.Load()
+}
+
+// ReverseProxy forwards requests to its upstreams, taking turns between the
+// healthy ones, and relays their responses (status, headers, streamed body
+// and trailers) to the client.
+type ReverseProxy struct {
+	Upstreams []*Upstream
+	// StripPrefix is removed from the request target before forwarding,
+	// e.g. "/api" sends "/api/users" upstream as "/users". It only matches
+	// whole path segments: "/apiary" is forwarded as it is.
+	StripPrefix string
+	// HealthCheckTimeout is how long a health check waits for an answer,
+	// DEFAULT_HEALTH_CHECK_TIMEOUT if 0. It never exceeds the interval.
+	HealthCheckTimeout time.Duration
+	// Client performs the upstream requests. It should not follow
+	// redirects, they are for the proxy's client to see.
+	Client *client.Client
+
+	next          atomic.Uint64
+	health_checks atomic.Bool
+	stop_checks   chan struct{}
+	checks        sync.WaitGroup
+}
+
+// NewReverseProxy returns a ReverseProxy for upstream_urls, which must be
+// absolute http or https URLs. A path in an upstream URL is put in front of
+// the forwarded target.
+func NewReverseProxy(upstream_urls ...string) (*ReverseProxy, error) {
+	if len(upstream_urls) == 0 {
+		return nil, errors.New("reverse proxy needs at least one upstream")
+	}
+	upstream_client := client.NewClient()
+	upstream_client.MaxRedirects = 0
+	reverse_proxy := &ReverseProxy{Client: upstream_client}
+	for _, upstream_url := range upstream_urls {
+		parsed, err := url.Parse(upstream_url)
+		if err != nil {
+			return nil, err
+		}
+		if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, errors.New("upstream must be an absolute http(s) URL: " + upstream_url)
+		}
+		upstream := &Upstream{URL: parsed}
+		upstream.healthy.Store(true)
+		reverse_proxy.Upstreams = append(reverse_proxy.Upstreams, upstream)
+	}
+	return reverse_proxy, nil
+}
+
+// StartHealthChecks requests path from every upstream now and then every
+// interval. Upstreams answering with anything but a 2xx or 3xx, or not at
+// all, get no requests until they pass a check again. While health checks
+// run, an upstream that cannot be reached is also taken out right away.
+func (rp *ReverseProxy) StartHealthChecks(path string, interval time.Duration) {
+	timeout := rp.HealthCheckTimeout
+	if timeout == 0 {
+		timeout = DEFAULT_HEALTH_CHECK_TIMEOUT
+	}
+	timeout = min(timeout, interval)
+	rp.health_checks.Store(true)
+	rp.stop_checks = make(chan struct{})
+	rp.checks.Add(1)
+	go func() {
+		defer rp.checks.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			rp.checkHealth(path, timeout)
+			select {
+			case <-rp.stop_checks:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the health checks and closes the upstream connections that are
+// kept alive for the next request.
+func (rp *ReverseProxy) Close() {
+	if rp.stop_checks != nil {
+		close(rp.stop_checks)
+		rp.checks.Wait()
+		rp.stop_checks = nil
+	}
+	rp.Client.CloseIdleConnections()
+}
+
+func (rp *ReverseProxy) checkHealth(path string, timeout time.Duration) {
+	for _, upstream := range rp.Upstreams {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		check, err := client.NewRequest(ctx, "GET", upstreamURL(upstream, path), nil)
+		healthy := false
+		if err == nil {
+			resp, err := rp.Client.Do(check)
+			if err == nil {
+				resp.Body.Close()
+				healthy = resp.StatusCode >= 200 && resp.StatusCode < 400
+			}
+		}
+		cancel()
+		if upstream.healthy.Swap(healthy) != healthy {
+			log.Println("Upstream " + upstream.URL.String() + " healthy: " + strconv.FormatBool(healthy))
+		}
+	}
+}
+
+// pickUpstream returns the next healthy upstream in turn, or nil.
+func (rp *ReverseProxy) pickUpstream() *Upstream {
+	start := rp.next.Add(1) - 1
+	for i := 0; i < len(rp.Upstreams); i++ {
+		upstream := rp.Upstreams[(start+uint64(i))%uint64(len(rp.Upstreams))]
+		if upstream.Healthy() {
+			return upstream
+		}
+	}
+	return nil
+}
+
+func upstreamURL(upstream *Upstream, target string) string {
+	return upstream.URL.Scheme + "://" + upstream.URL.Host + strings.TrimSuffix(upstream.URL.Path, "/") + target
+}
+
+// ServeRequest is the Handler of the proxy.
+func (rp *ReverseProxy) ServeRequest(w *response.Writer, r *request.Request) {
+	upstream := rp.pickUpstream()
+	if upstream == nil {
+		w.WriteHeader(response.SERVICE_UNAVAILABLE)
+		w.Write([]byte("No upstream is available"))
+		return
+	}
+	outgoing, err := rp.upstreamRequest(r, upstream)
+	if err != nil {
+		w.WriteHeader(response.CLIENT_ERROR)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	resp, err := rp.Client.Do(outgoing)
+	if err != nil {
+		cause := context.Cause(r.Context())
+		switch {
+		case errors.Is(cause, ErrClientDisconnected) || errors.Is(cause, ErrServerClosed):
+			return // nobody is waiting for an answer
+		case errors.Is(cause, ErrRequestTimeout):
+			w.WriteHeader(response.GATEWAY_TIMEOUT)
+			return
+		}
+		log.Println("Error while forwarding request to \"" + outgoing.URL.String() + "\": " + err.Error())
+		if rp.health_checks.Load() {
+			upstream.healthy.Store(false)
+		}
+		w.WriteHeader(response.BAD_GATEWAY)
+		return
+	}
+	defer resp.Body.Close()
+	rp.relayResponse(w, resp)
+}
+
+// stripPrefix removes prefix from target if it covers whole path segments of
+// it, i.e. the target ends or goes on with a new segment or the query after it.
+func stripPrefix(target string, prefix string) (string, bool) {
+	if prefix == "" || !strings.HasPrefix(target, prefix) {
+		return target, false
+	}
+	rest := target[len(prefix):]
+	if rest != "" && rest[0] != '/' && rest[0] != '?' && !strings.HasSuffix(prefix, "/") {
+		return target, false
+	}
+	return rest, true
+}
+
+func (rp *ReverseProxy) upstreamRequest(r *request.Request, upstream *Upstream) (*client.Request, error) {
+	target := r.RequestLine.RequestTarget
+	if rest, ok := stripPrefix(target, rp.StripPrefix); ok {
+		target = rest
+		if !strings.HasPrefix(target, "/") {
+			target = "/" + target
+		}
+	}
+	if !strings.HasPrefix(target, "/") {
+		return nil, errors.New("only origin-form targets can be forwarded: " + target)
+	}
+	outgoing, err := client.NewRequest(r.Context(), r.RequestLine.Method, upstreamURL(upstream, target), r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	skipped := connectionHeaders(r.Headers)
+	for key, value := range r.Headers {
+		if skipped[key] || key == "host" || key == "content-length" {
+			continue
+		}
+		outgoing.Headers.Set(key, value)
+	}
+
+	host, _ := r.Get("Host")
+	client_ip := r.RemoteAddr
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client_ip = ip
+	}
+	if client_ip != "" {
+		appendHeader(outgoing.Headers, "X-Forwarded-For", client_ip)
+	}
+	if host != "" {
+		outgoing.Headers.Set("X-Forwarded-Host", host)
+	}
+	outgoing.Headers.Set("X-Forwarded-Proto", "http")
+	appendHeader(outgoing.Headers, "Forwarded", forwardedElement(client_ip, host))
+	return outgoing, nil
+}
+
+// forwardedElement describes this hop for the Forwarded header (RFC 7239).
+func forwardedElement(client_ip, host string) string {
+	pairs := []string{}
+	if client_ip != "" {
+		node := client_ip
+		if strings.Contains(node, ":") {
+			node = "[" + node + "]" // IPv6
+		}
+		pairs = append(pairs, "for="+forwardedValue(node))
+	}
+	if host != "" {
+		pairs = append(pairs, "host="+forwardedValue(host))
+	}
+	pairs = append(pairs, "proto=http")
+	return strings.Join(pairs, ";")
+}
+
+func forwardedValue(value string) string {
+	if headers.IsToken(value) {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+func appendHeader(h headers.Headers, key, value string) {
+	if previous, _ := h.Get(key); previous != "" {
+		value = previous + ", " + value
+	}
+	h.Set(key, value)
+}
+
+// connectionHeaders returns the lower case names of the hop-by-hop headers
+// in h: the fixed ones and those listed in its Connection header.
+func connectionHeaders(h headers.Headers) map[string]bool {
+	skipped := map[string]bool{}
+	for _, name := range hopByHopHeaders {
+		skipped[name] = true
+	}
+	connection, _ := h.Get("Connection")
+	for _, name := range strings.Split(connection, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			skipped[strings.ToLower(name)] = true
+		}
+	}
+	return skipped
+}
+
+func (rp *ReverseProxy) relayResponse(w *response.Writer, resp *client.Response) {
+	skipped := connectionHeaders(resp.Headers)
+	for key, value := range resp.Headers {
+		if !skipped[key] {
+			w.Header().Set(key, value)
+		}
+	}
+
+	declared := false
+	trailer_names, _ := resp.Get("Trailer")
+	for _, name := range strings.Split(trailer_names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		err := w.DeclareTrailer(name)
+		if err != nil {
+			log.Println("Error while declaring upstream trailer: " + err.Error())
+			continue
+		}
+		declared = true
+	}
+	if declared {
+		w.Header().Delete("Content-Length") // trailers need a chunked body
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	buffer := make([]byte, PROXY_BUFFER_SIZE)
+	for {
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			_, write_err := w.Write(buffer[:n])
+			if write_err == nil {
+				write_err = w.Flush()
+			}
+			if write_err != nil {
+				log.Println("Error while relaying response body: " + write_err.Error())
+				w.Close()
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the client must not mistake a cut off body for a complete one
+			log.Println("Error while reading upstream response body: " + err.Error())
+			w.Close()
+			return
+		}
+	}
+
+	for key, value := range resp.Trailers {
+		w.Trailer().Set(key, value)
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedUpstream answers every request with its name and health with 200.
+func namedUpstream(t *testing.T, name string) string {
+	return startServer(t, func(w *response.Writer, r *request.Request) {
+		w.Write([]byte(name))
+	})
+}
+
+func TestReverseProxy(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, r *request.Request) {
+		_, secret := r.Get("X-Secret")
+		_, keep_alive := r.Get("Keep-Alive")
+		forwarded_for, _ := r.Get("X-Forwarded-For")
+		forwarded, _ := r.Get("Forwarded")
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.WriteHeader(response.NOT_FOUND)
+		fmt.Fprintf(w, "%s %s\n", r.RequestLine.Method, r.RequestLine.RequestTarget)
+		fmt.Fprintf(w, "secret=%t keep-alive=%t\n", secret, keep_alive)
+		fmt.Fprintf(w, "x-forwarded-for=%s\n", forwarded_for)
+		fmt.Fprintf(w, "forwarded=%s\n", forwarded)
+		w.Write(r.Body)
+	})
+	proxy, err := NewReverseProxy("http://" + upstream + "/base")
+	require.NoError(t, err)
+	proxy.StripPrefix = "/api"
+	address := startServer(t, proxy.ServeRequest)
+
+	// Test: Method, body, status and headers are forwarded
+	reply, err := roundTripOpen(t, address, "POST /api/items?x=1 HTTP/1.1\r\nHost: example.com\r\n"+
+		"Connection: close, X-Secret\r\nX-Secret: 1\r\nKeep-Alive: 1\r\nX-Forwarded-For: 10.0.0.1\r\n"+
+		"Content-Length: 5\r\n\r\nhello")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 404 Not Found\r\n"), reply)
+	assert.Contains(t, reply, "x-upstream: yes\r\n")
+	assert.NotContains(t, reply, "keep-alive: timeout=5")
+	assert.Contains(t, reply, "POST /base/items?x=1\n")
+	assert.Contains(t, reply, "secret=false keep-alive=false\n")
+	assert.Contains(t, reply, "x-forwarded-for=10.0.0.1, 127.0.0.1\n")
+	assert.Contains(t, reply, "forwarded=for=127.0.0.1;host=example.com;proto=http\n")
+	assert.True(t, strings.HasSuffix(reply, "\nhello"), reply)
+
+	// Test: Prefix is only stripped as a whole path segment
+	for target, forwarded := range map[string]string{
+		"/apiary":  "/base/apiary",
+		"/api":     "/base/",
+		"/api?x=1": "/base/?x=1",
+	} {
+		reply, err = roundTripOpen(t, address, "GET "+target+" HTTP/1.1\r\nHost: example.com\r\n\r\n")
+		require.NoError(t, err)
+		assert.Contains(t, reply, "GET "+forwarded+"\n")
+	}
+
+	// Test: Target that is not in origin-form
+	reply, err = roundTripOpen(t, address, "OPTIONS * HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 400 Bad Request\r\n"), reply)
+}
+
+func TestReverseProxyStreamsTrailers(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, r *request.Request) {
+		w.DeclareTrailer("X-Checksum")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked", "content-type": "text/plain"})
+		w.WriteChunkedBody([]byte("first "))
+		w.WriteChunkedBody([]byte("second"))
+		w.WriteChunkedBodyDone()
+		w.Trailer().Set("X-Checksum", "abc123")
+		w.WriteTrailers(nil)
+	})
+	proxy, err := NewReverseProxy("http://" + upstream)
+	require.NoError(t, err)
+	address := startServer(t, proxy.ServeRequest)
+
+	// Test: Chunked upstream body arrives chunked with its trailer
+	reply, err := roundTripOpen(t, address, "GET /stream HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 200 OK\r\n"), reply)
+	assert.Contains(t, reply, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, reply, "trailer: X-Checksum\r\n")
+	assert.Contains(t, reply, "first ")
+	assert.Contains(t, reply, "second")
+	assert.True(t, strings.HasSuffix(reply, "0\r\nX-Checksum: abc123\r\n\r\n"), reply)
+}
+
+func TestReverseProxyBalancing(t *testing.T) {
+	failing := startServer(t, func(w *response.Writer, r *request.Request) {
+		w.WriteHeader(response.SERVICE_UNAVAILABLE)
+	})
+	proxy, err := NewReverseProxy("http://"+namedUpstream(t, "a"), "http://"+namedUpstream(t, "b"), "http://"+failing)
+	require.NoError(t, err)
+	address := startServer(t, proxy.ServeRequest)
+
+	// Test: Upstreams take turns
+	bodies := []string{}
+	for i := 0; i < 3; i++ {
+		reply, err := roundTripOpen(t, address, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		bodies = append(bodies, reply[strings.Index(reply, "\r\n\r\n")+4:])
+	}
+	assert.Equal(t, []string{"a", "b", ""}, bodies)
+
+	// Test: Failing health check takes an upstream out
+	proxy.StartHealthChecks("/health", 20*time.Millisecond)
+	t.Cleanup(proxy.Close)
+	require.Eventually(t, func() bool { return !proxy.Upstreams[2].Healthy() }, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, proxy.Upstreams[0].Healthy())
+	for i := 0; i < 4; i++ {
+		reply, err := roundTripOpen(t, address, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 200 OK\r\n"), reply)
+	}
+
+	// Test: No healthy upstream
+	proxy.Close()
+	for _, upstream := range proxy.Upstreams {
+		upstream.healthy.Store(false)
+	}
+	reply, err := roundTripOpen(t, address, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 503 Service Unavailable\r\n"), reply)
+}
+
+func TestReverseProxyHealthCheckTimeout(t *testing.T) {
+	hanging := startServer(t, func(w *response.Writer, r *request.Request) {
+		<-r.Context().Done()
+	})
+	proxy, err := NewReverseProxy("http://" + hanging)
+	require.NoError(t, err)
+	proxy.HealthCheckTimeout = 50 * time.Millisecond
+
+	// Test: An upstream that does not answer fails its check long before the
+	// next one is due
+	proxy.StartHealthChecks("/health", time.Hour)
+	t.Cleanup(proxy.Close)
+	require.Eventually(t, func() bool { return !proxy.Upstreams[0].Healthy() }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReverseProxyClose(t *testing.T) {
+	upstream_metrics := NewMetrics()
+	upstream := startServer(t, func(w *response.Writer, r *request.Request) {
+		w.Write([]byte("ok"))
+	}, WithMetrics(upstream_metrics))
+	proxy, err := NewReverseProxy("http://" + upstream)
+	require.NoError(t, err)
+	address := startServer(t, proxy.ServeRequest)
+
+	// Test: Close closes the upstream connection kept alive after a request
+	reply, err := roundTripOpen(t, address, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\nok"), reply)
+	assert.Equal(t, float64(1), upstream_metrics.active_connections.Value())
+	proxy.Close()
+	require.Eventually(t, func() bool { return upstream_metrics.active_connections.Value() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+// Router dispatches requests to handlers by method and path. A path that ends
+// with "/" also matches everything below it (the longest match wins), any
+// other path matches only itself. The query string is ignored for matching.
+//
+// HEAD requests are served by the GET handler of the path unless a HEAD
+// handler was registered; the server makes sure no body is sent for them.
+// OPTIONS requests without a registered handler are answered with the
+// methods the path (or the whole server, for "OPTIONS *") answers to.
+//
+// CONNECT targets are host:port authorities rather than paths, so they are
+// dispatched to the handler given to HandleConnect.
+type Router struct {
+	routes  map[string]map[string]Handler // path -> method -> handler
+	trace   Handler
+	connect Handler
+}
+
+func NewRouter() *Router {
+	return &Router{routes: map[string]map[string]Handler{}}
+}
+
+func (rt *Router) Handle(method, path string, handler Handler) {
+	if rt.routes[path] == nil {
+		rt.routes[path] = map[string]Handler{}
+	}
+	rt.routes[path][method] = handler
+}
+
+// HandleConnect sets the handler for CONNECT requests, see NewConnectHandler.
+func (rt *Router) HandleConnect(handler Handler) {
+	rt.connect = handler
+}
+
+// EnableTrace makes the router answer TRACE requests on every path with
+// TraceHandler. TRACE is off by default: echoing requests back can leak
+// headers added by proxies on the way.
+func (rt *Router) EnableTrace() {
+	rt.trace = TraceHandler
+}
+
+// ServeRequest is the Handler of the router, pass it to Serve.
+func (rt *Router) ServeRequest(w *response.Writer, r *request.Request) {
+	if r.RequestLine.Method == "TRACE" && rt.trace != nil {
+		rt.trace(w, r)
+		return
+	}
+	if r.RequestLine.Method == "CONNECT" {
+		if rt.connect == nil {
+			w.WriteHeader(response.NOT_IMPLEMENTED)
+			w.Write([]byte("Not Implemented"))
+			return
+		}
+		rt.connect(w, r)
+		return
+	}
+	if r.RequestLine.Method == "OPTIONS" && r.RequestLine.RequestTarget == "*" {
+		rt.serveOptions(w, rt.allMethods())
+		return
+	}
+
+	methods, ok := rt.match(r.RequestLine.RequestTarget)
+	if !ok {
+		w.WriteHeader(response.NOT_FOUND)
+		w.Write([]byte("Not Found"))
+		return
+	}
+
+	handler, ok := methods[r.RequestLine.Method]
+	if !ok && r.RequestLine.Method == "HEAD" {
+		handler, ok = methods["GET"]
+	}
+	if !ok && r.RequestLine.Method == "OPTIONS" {
+		rt.serveOptions(w, methods)
+		return
+	}
+	if !ok {
+		w.Header().Set("Allow", strings.Join(rt.allowedMethods(methods), ", "))
+		w.WriteHeader(response.METHOD_NOT_ALLOWED)
+		w.Write([]byte("Method Not Allowed"))
+		return
+	}
+	handler(w, r)
+}
+
+func (rt *Router) match(target string) (map[string]Handler, bool) {
+	path := target
+	if index := strings.IndexAny(path, "?#"); index != -1 {
+		path = path[:index]
+	}
+	if methods, ok := rt.routes[path]; ok {
+		return methods, true
+	}
+
+	longest := ""
+	for pattern := range rt.routes {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) && len(pattern) > len(longest) {
+			longest = pattern
+		}
+	}
+	if longest == "" {
+		return nil, false
+	}
+	return rt.routes[longest], true
+}
+
+func (rt *Router) serveOptions(w *response.Writer, methods map[string]Handler) {
+	w.Header().Set("Allow", strings.Join(rt.allowedMethods(methods), ", "))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(response.OK)
+}
+
+// allMethods merges the methods of every route, for "OPTIONS *".
+func (rt *Router) allMethods() map[string]Handler {
+	all := map[string]Handler{}
+	for _, methods := range rt.routes {
+		for method, handler := range methods {
+			all[method] = handler
+		}
+	}
+	return all
+}
+
+// allowedMethods lists the methods a path answers to: the registered ones,
+// HEAD when GET is registered, OPTIONS, and TRACE when it is enabled.
+func (rt *Router) allowedMethods(methods map[string]Handler) []string {
+	set := map[string]struct{}{"OPTIONS": {}}
+	for method := range methods {
+		set[method] = struct{}{}
+	}
+	if _, has_get := methods["GET"]; has_get {
+		set["HEAD"] = struct{}{}
+	}
+	if rt.trace != nil {
+		set["TRACE"] = struct{}{}
+	}
+	if rt.connect != nil {
+		set["CONNECT"] = struct{}{}
+	}
+
+	allowed := []string{}
+	for method := range set {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	return allowed
+}
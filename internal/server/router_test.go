@@ -0,0 +1,142 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func textHandler(text string) Handler {
+	return func(w *response.Writer, r *request.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(text))
+	}
+}
+
+func TestRouter(t *testing.T) {
+	router := NewRouter()
+	router.Handle("GET", "/", textHandler("root"))
+	router.Handle("GET", "/coffee", textHandler("coffee"))
+	router.Handle("POST", "/coffee", textHandler("brewing"))
+	router.Handle("GET", "/static/", textHandler("static"))
+	router.Handle("GET", "/static/img/", textHandler("image"))
+	address := startServer(t, router.ServeRequest)
+
+	// Test: Exact match
+	reply, err := roundTrip(t, address, "GET /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\ncoffee"), reply)
+
+	// Test: Method picks the handler, query string is ignored
+	reply, err = roundTrip(t, address, "POST /coffee?size=large HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\nbrewing"), reply)
+
+	// Test: Longest prefix wins
+	reply, err = roundTrip(t, address, "GET /static/img/logo.png HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\nimage"), reply)
+	reply, err = roundTrip(t, address, "GET /static/app.js HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\nstatic"), reply)
+
+	// Test: Method not allowed lists the allowed ones
+	reply, err = roundTrip(t, address, "DELETE /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 405 Method Not Allowed\r\n"), reply)
+	assert.Contains(t, reply, "allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: Not found without a catch-all
+	router = NewRouter()
+	router.Handle("GET", "/coffee", textHandler("coffee"))
+	address = startServer(t, router.ServeRequest)
+	reply, err = roundTrip(t, address, "GET /tea HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 404 Not Found\r\n"), reply)
+}
+
+func TestHeadRequests(t *testing.T) {
+	router := NewRouter()
+	router.Handle("GET", "/coffee", textHandler("a fresh cup of coffee"))
+	router.Handle("GET", "/html", func(w *response.Writer, r *request.Request) {
+		handler_response := HandlerResponse{StatusCode: response.OK, Message: "<p>hi</p>"}
+		handler_response.SetHeader("Content-Type", "text/html")
+		handler_response.HandlerResponseWriter(w)
+	})
+	router.Handle("GET", "/stream", func(w *response.Writer, r *request.Request) {
+		w.Write([]byte(strings.Repeat("s", response.BODY_BUFFER_SIZE+1)))
+	})
+	address := startServer(t, router.ServeRequest)
+
+	// Test: HEAD is routed to GET, keeps the Content-Length and sends no body
+	reply, err := roundTrip(t, address, "HEAD /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 200 OK\r\n"), reply)
+	assert.Contains(t, reply, "content-length: 21\r\n")
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\n"), reply)
+
+	// Test: Low level writes are discarded too
+	reply, err = roundTrip(t, address, "HEAD /html HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Contains(t, reply, "content-length: 9\r\n")
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\n"), reply)
+
+	// Test: Bodies too large to buffer still get their length
+	reply, err = roundTrip(t, address, "HEAD /stream HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Contains(t, reply, "content-length: 4097\r\n")
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\n"), reply)
+
+	// Test: GET still gets the body
+	reply, err = roundTrip(t, address, "GET /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\na fresh cup of coffee"), reply)
+}
+
+func TestOptionsAndTrace(t *testing.T) {
+	router := NewRouter()
+	router.Handle("GET", "/coffee", textHandler("coffee"))
+	router.Handle("POST", "/coffee", textHandler("brewing"))
+	router.Handle("DELETE", "/orders/", textHandler("cancelled"))
+	address := startServer(t, router.ServeRequest)
+
+	// Test: OPTIONS on a path lists its methods
+	reply, err := roundTrip(t, address, "OPTIONS /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 200 OK\r\n"), reply)
+	assert.Contains(t, reply, "allow: GET, HEAD, OPTIONS, POST\r\n")
+	assert.Contains(t, reply, "content-length: 0\r\n")
+
+	// Test: OPTIONS * lists the methods of every route
+	reply, err = roundTrip(t, address, "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Contains(t, reply, "allow: DELETE, GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: OPTIONS on an unknown path
+	reply, err = roundTrip(t, address, "OPTIONS /tea HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 404 Not Found\r\n"), reply)
+
+	// Test: TRACE is off by default
+	reply, err = roundTrip(t, address, "TRACE /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 405 Method Not Allowed\r\n"), reply)
+
+	// Test: TRACE echoes the request with credentials redacted
+	router.EnableTrace()
+	reply, err = roundTrip(t, address, "TRACE /coffee HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer secret\r\nCookie: session=secret\r\nX-Trace: 1\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 200 OK\r\n"), reply)
+	assert.Contains(t, reply, "content-type: message/http\r\n")
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\nTRACE /coffee HTTP/1.1\r\nauthorization: [redacted]\r\ncookie: [redacted]\r\nhost: localhost\r\nx-trace: 1\r\n\r\n"), reply)
+	assert.NotContains(t, reply, "secret")
+
+	// Test: Enabled TRACE shows up in Allow
+	reply, err = roundTrip(t, address, "OPTIONS /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Contains(t, reply, "allow: GET, HEAD, OPTIONS, POST, TRACE\r\n")
+}
@@ -1,30 +1,88 @@
 package server
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"io"
 	"log"
 	"net"
 	"strconv"
 	"sync/atomic"
+	"time"
 
 	"github.com/OmarJarbou/httpfromtcp/internal/request"
 	"github.com/OmarJarbou/httpfromtcp/internal/response"
 )
 
+// LINGER_TIMEOUT bounds how long a connection is drained after an error
+// response, see closeLingering.
+const LINGER_TIMEOUT = 500 * time.Millisecond
+
+// DEFAULT_IDLE_TIMEOUT is how long a connection may wait for the first byte
+// of its next request before the server closes it.
+const DEFAULT_IDLE_TIMEOUT = 60 * time.Second
+
+// DEFAULT_MAX_BODY_SIZE is the largest request body a server reads unless
+// WithMaxBodySize says otherwise. Bodies are held in memory whole.
+const DEFAULT_MAX_BODY_SIZE = 64 << 20
+
 type Server struct {
-	Listener net.Listener
-	Handler  Handler
-	Closed   atomic.Bool
+	Listener  net.Listener
+	Handler   Handler
+	Closed    atomic.Bool
+	AccessLog *AccessLogger
+	Metrics   *Metrics
+	// RequestTimeout is the deadline of every request context, 0 for none.
+	RequestTimeout time.Duration
+	// IdleTimeout is how long a connection may wait for a request, see
+	// WithIdleTimeout.
+	IdleTimeout time.Duration
+	// MaxBodySize limits request bodies, see WithMaxBodySize.
+	MaxBodySize int
+
+	base_ctx    context.Context
+	cancel_base context.CancelCauseFunc
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
-	server := Server{}
+// Option configures a Server before it starts accepting connections.
+type Option func(*Server)
+
+// WithAccessLog makes the server write one access log record per request.
+func WithAccessLog(access_log *AccessLogger) Option {
+	return func(s *Server) {
+		s.AccessLog = access_log
+	}
+}
+
+// WithIdleTimeout sets how long a connection may wait for the first byte of
+// a request, 0 for no limit. The default is DEFAULT_IDLE_TIMEOUT.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.IdleTimeout = timeout
+	}
+}
+
+// WithMaxBodySize sets the largest request body the server reads, 0 for no
+// limit. Larger requests are answered with 413 Content Too Large without
+// reaching the handler. The default is DEFAULT_MAX_BODY_SIZE.
+func WithMaxBodySize(max_size int) Option {
+	return func(s *Server) {
+		s.MaxBodySize = max_size
+	}
+}
+
+func Serve(port int, handler Handler, options ...Option) (*Server, error) {
+	server := Server{IdleTimeout: DEFAULT_IDLE_TIMEOUT, MaxBodySize: DEFAULT_MAX_BODY_SIZE}
+	for _, option := range options {
+		option(&server)
+	}
 	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
 	if err != nil {
 		return &server, err
 	}
 	server.Handler = handler
 	server.Listener = listener
+	server.base_ctx, server.cancel_base = context.WithCancelCause(context.Background())
 
 	go server.listen()
 
@@ -32,9 +90,12 @@ func Serve(port int, handler Handler) (*Server, error) {
 }
 
 func (s *Server) Close() error {
-	err := s.Listener.Close()
+	// mark as closed first, so listen does not treat the failing Accept as fatal
 	s.Closed.Store(true)
-	return err
+	if s.cancel_base != nil {
+		s.cancel_base(ErrServerClosed)
+	}
+	return s.Listener.Close()
 }
 
 func (s *Server) listen() {
@@ -47,25 +108,173 @@ func (s *Server) listen() {
 			log.Fatal(err)
 			return
 		}
-		fmt.Println("A connection has been accepted")
 		go s.handle(connection)
 	}
 }
 
+// connection is what handle keeps across the requests of one connection.
+type connection struct {
+	conn     net.Conn
+	counter  *readCounter
+	reader   *request.Reader
+	hijacked bool
+	// bytes of counter already added to the metrics
+	bytes_reported int64
+}
+
+// handle serves the requests of conn one after the other, in the order they
+// were sent, for as long as both sides keep the connection alive. Requests
+// the client pipelined are read as the previous response goes out, so
+// responses are always written in request order.
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	writer := response.Writer{
-		Writer:      conn,
-		WriterState: response.STATUS_LINE,
+	counter := &readCounter{reader: conn}
+	c := &connection{conn: conn, counter: counter, reader: request.NewReader(counter)}
+	c.reader.MaxBodySize = s.MaxBodySize
+	s.Metrics.connectionOpened()
+	defer func() {
+		// what was read after the last request, e.g. the end of the stream
+		s.Metrics.transferred(counter.bytes_read-c.bytes_reported, 0)
+		s.Metrics.connectionClosed()
+		if !c.hijacked {
+			conn.Close()
+		}
+	}()
+	for s.serveRequest(c) && !s.Closed.Load() {
 	}
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		handler_response := &HandlerResponse{
-			StatusCode: response.SERVER_ERROR,
-			Message:    err.Error(),
+}
+
+// serveRequest reads the next request from c and answers it. It reports
+// whether the connection can carry another request.
+func (s *Server) serveRequest(c *connection) (keep_alive bool) {
+	conn := c.conn
+	req, start, err := s.readRequest(c)
+	if err == io.EOF {
+		return false // the client left, or never sent the next request
+	}
+	recorder := &responseRecorder{writer: conn}
+	writer := response.NewWriter(recorder)
+	defer func() {
+		c.hijacked = writer.Hijacked()
+		s.Metrics.transferred(c.counter.bytes_read-c.bytes_reported, recorder.bytes_written)
+		c.bytes_reported = c.counter.bytes_read
+		s.Metrics.observeRequest(req, recorder.status, time.Since(start))
+		s.logAccess(conn, req, recorder, start)
+	}()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			s.recoverPanic(recovered, conn, req, writer)
+			keep_alive = false
 		}
-		handler_response.HandlerResponseWriter(writer)
+	}()
+
+	if err != nil {
+		s.handleParseError(writer, err)
+		closeLingering(conn)
+		return false
 	}
 
+	if req.RequestLine.Method == "HEAD" {
+		writer.OmitBody()
+	}
+	if !hasToken(req.Headers, "Connection", "close") && !s.Closed.Load() {
+		writer.EnableKeepAlive()
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel(context.Canceled)
+	req = req.WithContext(ctx)
+	req.RemoteAddr = conn.RemoteAddr().String()
+	writer.EnableHijack(conn, req.Buffered())
+	if !c.counter.eof {
+		// a client that already half-closed cannot be watched for leaving
+		watcher := watchConnection(conn, c.counter, cancel)
+		defer func() {
+			c.reader.Unread(watcher.stop())
+		}()
+		writer.OnHijack(watcher.stop)
+	}
+	defer func() {
+		// temporary files of a form the handler parsed do not outlive it
+		if req.MultipartForm != nil {
+			req.MultipartForm.RemoveAll()
+		}
+	}()
 	s.Handler(writer, req)
+	err = writer.Finish()
+	if err != nil {
+		log.Println("Error while finishing response: " + err.Error())
+		return false
+	}
+	return writer.KeepAlive()
+}
+
+// handleParseError answers a request that could not be parsed; the handler is
+// never invoked for it.
+func (s *Server) handleParseError(w *response.Writer, err error) {
+	if errors.Is(err, io.EOF) {
+		return // the client connected and left without sending a request
+	}
+	s.Metrics.parseError(err)
+	handler_response := &HandlerResponse{}
+	handler_response.HandlerErrorResponse(w, parseErrorStatus(err), err.Error())
+	err = w.Finish()
+	if err != nil {
+		log.Println("Error while finishing response: " + err.Error())
+	}
+}
+
+// closeLingering stops writing and drains what the client is still sending
+// for a moment before the connection is closed. Closing a socket with unread
+// data makes the kernel send a reset, which can destroy the error response
+// before the client gets to read it.
+func closeLingering(conn net.Conn) {
+	tcp_conn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	tcp_conn.CloseWrite()
+	tcp_conn.SetReadDeadline(time.Now().Add(LINGER_TIMEOUT))
+	io.Copy(io.Discard, tcp_conn)
+}
+
+func parseErrorStatus(err error) response.StatusCode {
+	var parse_err *request.ParseError
+	if !errors.As(err, &parse_err) {
+		return response.SERVER_ERROR
+	}
+	switch parse_err.Kind {
+	case request.UNSUPPORTED_VERSION:
+		return response.HTTP_VERSION_NOT_SUPPORTED
+	case request.UNSUPPORTED_METHOD:
+		return response.NOT_IMPLEMENTED
+	case request.HEAD_TOO_LARGE:
+		return response.REQUEST_HEADER_FIELDS_TOO_LARGE
+	case request.BODY_TOO_LARGE:
+		return response.CONTENT_TOO_LARGE
+	}
+	return response.CLIENT_ERROR
+}
+
+func (s *Server) logAccess(conn net.Conn, req *request.Request, recorder *responseRecorder, start time.Time) {
+	if s.AccessLog == nil {
+		return
+	}
+	entry := AccessLogEntry{
+		Time:         start,
+		RemoteAddr:   conn.RemoteAddr().String(),
+		Status:       recorder.status,
+		BytesWritten: recorder.body_bytes,
+		Duration:     time.Since(start),
+	}
+	if req != nil {
+		entry.Method = req.RequestLine.Method
+		entry.Target = req.RequestLine.RequestTarget
+		entry.HttpVersion = req.RequestLine.HttpVersion
+		entry.Referer, _ = req.Get("Referer")
+		entry.UserAgent, _ = req.Get("User-Agent")
+		entry.RequestID, _ = req.Get("X-Request-Id")
+	}
+	if entry.RequestID == "" {
+		entry.RequestID = newRequestID()
+	}
+	s.AccessLog.Log(entry)
 }
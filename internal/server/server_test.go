@@ -0,0 +1,198 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves handler on a random local port and returns its address.
+func startServer(t *testing.T, handler Handler, options ...Option) string {
+	t.Helper()
+	server, err := Serve(0, handler, options...)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return "127.0.0.1:" + strconv.Itoa(server.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+// roundTrip sends raw on a new connection, closes the sending side and
+// returns everything the server wrote back until it closed the connection.
+func roundTrip(t *testing.T, address, raw string) (string, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	reply, err := io.ReadAll(conn)
+	return string(reply), err
+}
+
+// roundTripOpen is roundTrip for handlers that watch their request context:
+// the sending side stays open, since a half-close counts as the client going
+// away. It returns the raw bytes of the first response.
+func roundTripOpen(t *testing.T, address, raw string) (string, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	reply := &strings.Builder{}
+	method, _, _ := strings.Cut(raw, " ")
+	resp, err := response.ResponseFromReader(io.TeeReader(conn, reply), method)
+	if err != nil {
+		return reply.String(), err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return reply.String(), err
+}
+
+func TestPanicRecovery(t *testing.T) {
+	// Test: Panic before anything was written gets a 500
+	address := startServer(t, func(w *response.Writer, r *request.Request) {
+		panic("boom")
+	})
+	reply, err := roundTrip(t, address, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 500 Internal Server Error\r\n"), reply)
+
+	// Test: Server keeps serving after a panic
+	reply, err = roundTrip(t, address, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 500 Internal Server Error\r\n"), reply)
+
+	// Test: Cookies set before the panic are not sent with the 500
+	address = startServer(t, func(w *response.Writer, r *request.Request) {
+		w.SetCookie(&response.Cookie{Name: "session", Value: "abc"})
+		w.DeclareTrailer("X-Checksum")
+		panic("boom")
+	})
+	reply, err = roundTrip(t, address, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 500 Internal Server Error\r\n"), reply)
+	assert.NotContains(t, reply, "set-cookie")
+	assert.NotContains(t, reply, "trailer")
+
+	// Test: Panic after the status line was written aborts the connection
+	address = startServer(t, func(w *response.Writer, r *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers.Headers{"content-length": "100"})
+		panic("boom")
+	})
+	reply, err = roundTrip(t, address, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.Error(t, err)
+	assert.NotContains(t, reply, "500")
+}
+
+func TestParseErrorResponse(t *testing.T) {
+	handler_called := false
+	address := startServer(t, func(w *response.Writer, r *request.Request) {
+		handler_called = true
+	})
+
+	// Test: Malformed request line
+	reply, err := roundTrip(t, address, "GET /coffee\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 400 Bad Request\r\n"), reply)
+
+	// Test: Malformed header
+	reply, err = roundTrip(t, address, "GET / HTTP/1.1\r\nHost localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 400 Bad Request\r\n"), reply)
+
+	// Test: Unsupported version
+	reply, err = roundTrip(t, address, "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 505 HTTP Version Not Supported\r\n"), reply)
+
+	// Test: Unknown method
+	reply, err = roundTrip(t, address, "BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 501 Not Implemented\r\n"), reply)
+
+	// Test: Body over the limit
+	limited := startServer(t, func(w *response.Writer, r *request.Request) {
+		handler_called = true
+	}, WithMaxBodySize(4))
+	reply, err = roundTrip(t, limited, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 413 Content Too Large\r\n"), reply)
+
+	// Test: Head that is too large
+	reply, err = roundTrip(t, address, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Large: "+strings.Repeat("a", request.MAX_HEAD_SIZE)+"\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 431 Request Header Fields Too Large\r\n"), reply)
+
+	// Test: Connection closed without a request gets no response
+	reply, err = roundTrip(t, address, "")
+	require.NoError(t, err)
+	assert.Equal(t, "", reply)
+
+	assert.False(t, handler_called)
+}
+
+func TestRequestSmuggling(t *testing.T) {
+	targets := make(chan string, 10)
+	address := startServer(t, func(w *response.Writer, r *request.Request) {
+		targets <- r.RequestLine.RequestTarget
+		w.Write(r.Body)
+	})
+
+	// Test: Ambiguous framing is a 400 and nothing behind it is served
+	for _, raw := range []string{
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 44\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nGET /smuggled HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n2e\r\nGET /smuggled HTTP/1.1\r\nHost: localhost\r\n\r\n\r\n0\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0, 44\r\n\r\nGET /smuggled HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\nGET /smuggled HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: localhost\r\nX-Folded: a\r\n Content-Length: 44\r\n\r\nGET /smuggled HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	} {
+		reply, err := roundTrip(t, address, raw)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(reply, "HTTP/1.1 400 Bad Request\r\n"), reply)
+		assert.Equal(t, 1, strings.Count(reply, "HTTP/1.1 "), reply)
+	}
+	assert.Empty(t, targets)
+
+	// Test: Chunked body is decoded and the next request read after it
+	reply, err := roundTrip(t, address, "POST /chunked HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n0\r\n\r\nGET /next HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Contains(t, reply, "\r\n\r\nhelloHTTP/1.1 200 OK\r\n")
+	assert.Equal(t, "/chunked", <-targets)
+	assert.Equal(t, "/next", <-targets)
+}
+
+func TestMultipartFormCleanup(t *testing.T) {
+	tmp_files := make(chan string, 1)
+	address := startServer(t, func(w *response.Writer, r *request.Request) {
+		err := r.ParseMultipartForm(request.MultipartLimits{MaxMemory: 1})
+		if err != nil {
+			w.WriteHeader(response.CLIENT_ERROR)
+			return
+		}
+		file, _ := r.MultipartForm.File["upload"][0].Open()
+		defer file.Close()
+		tmp_files <- file.(*os.File).Name()
+		io.Copy(w, file)
+	})
+
+	// Test: Temporary files are removed once the handler returns
+	body := "--b\r\nContent-Disposition: form-data; name=\"upload\"; filename=\"a.txt\"\r\n\r\nuploaded\r\n--b--\r\n"
+	reply, err := roundTrip(t, address, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Type: multipart/form-data; boundary=b\r\n"+
+		"Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body)
+	require.NoError(t, err)
+	assert.Contains(t, reply, "\r\nuploaded\r\n")
+	_, err = os.Stat(<-tmp_files)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+// DEFAULT_HEARTBEAT_INTERVAL is how often an idle event stream sends a
+// comment, which keeps proxies from timing the stream out and reveals
+// clients that went away.
+const DEFAULT_HEARTBEAT_INTERVAL = 15 * time.Second
+
+// Event is one Server-Sent Event. Empty fields are not sent; Data may span
+// several lines.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// EventStream writes a text/event-stream response. Once it exists the handler
+// must only write to the response through it; it is safe to use from several
+// goroutines. The stream ends when the handler returns; Close, which stops
+// the heartbeat, is then called if the handler did not.
+type EventStream struct {
+	// LastEventID is the Last-Event-ID a reconnecting client sent, so the
+	// handler can resume after the last event the client saw.
+	LastEventID string
+
+	writer    *response.Writer
+	mutex     sync.Mutex
+	done      chan struct{}
+	err       error
+	stop      chan struct{}
+	heartbeat sync.WaitGroup
+}
+
+// NewEventStream sends the headers of an event stream and starts sending a
+// heartbeat comment every heartbeat_interval (none if it is 0). The stream is
+// done as well once the request context is.
+func NewEventStream(w *response.Writer, r *request.Request, heartbeat_interval time.Duration) (*EventStream, error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(response.OK)
+	err := w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &EventStream{
+		writer: w,
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	stream.LastEventID, _ = r.Get("Last-Event-ID")
+	stream.heartbeat.Add(1)
+	go stream.sendHeartbeats(r.Context(), heartbeat_interval)
+	// the heartbeat must not write while or after the server ends the
+	// response, also for a handler that returns without Close
+	w.OnFinish(stream.Close)
+	return stream, nil
+}
+
+func (es *EventStream) sendHeartbeats(ctx context.Context, interval time.Duration) {
+	defer es.heartbeat.Done()
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-es.stop:
+			return
+		case <-es.done:
+			return
+		case <-ctx.Done():
+			es.fail(context.Cause(ctx))
+			return
+		case <-tick:
+			es.write(": heartbeat\n\n")
+		}
+	}
+}
+
+// Send writes event to the client.
+func (es *EventStream) Send(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n\x00") || strings.ContainsAny(event.Event, "\r\n") {
+		return errors.New("event id and event name must be a single line")
+	}
+	return es.write(formatEvent(event))
+}
+
+func formatEvent(event Event) string {
+	text := ""
+	if event.ID != "" {
+		text += "id: " + event.ID + "\n"
+	}
+	if event.Event != "" {
+		text += "event: " + event.Event + "\n"
+	}
+	if event.Retry > 0 {
+		text += "retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n"
+	}
+	if event.Data != "" || event.Event != "" {
+		data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+		for _, line := range strings.Split(data, "\n") {
+			text += "data: " + line + "\n"
+		}
+	}
+	return text + "\n"
+}
+
+func (es *EventStream) write(text string) error {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	if es.err != nil {
+		return es.err
+	}
+	_, err := es.writer.Write([]byte(text))
+	if err == nil {
+		err = es.writer.Flush()
+	}
+	if err != nil {
+		es.failLocked(err)
+	}
+	return err
+}
+
+func (es *EventStream) fail(err error) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	es.failLocked(err)
+}
+
+func (es *EventStream) failLocked(err error) {
+	if es.err == nil {
+		es.err = err
+		close(es.done)
+	}
+}
+
+// Done is closed once a write to the client failed or the request context
+// ended, e.g. because the client disconnected; Err then tells why.
+func (es *EventStream) Done() <-chan struct{} {
+	return es.done
+}
+
+func (es *EventStream) Err() error {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	return es.err
+}
+
+// Close stops the heartbeat. The handler should return afterwards so the
+// server can end the response.
+func (es *EventStream) Close() {
+	es.mutex.Lock()
+	select {
+	case <-es.stop:
+	default:
+		close(es.stop)
+	}
+	es.mutex.Unlock()
+	es.heartbeat.Wait()
+}
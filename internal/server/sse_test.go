@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatEvent(t *testing.T) {
+	// Test: All fields with multi-line data
+	text := formatEvent(Event{ID: "7", Event: "update", Data: "line one\nline two\r\nline three", Retry: 3 * time.Second})
+	assert.Equal(t, "id: 7\nevent: update\nretry: 3000\ndata: line one\ndata: line two\ndata: line three\n\n", text)
+
+	// Test: Data only
+	assert.Equal(t, "data: hello\n\n", formatEvent(Event{Data: "hello"}))
+
+	// Test: Retry only sends no data line
+	assert.Equal(t, "retry: 500\n\n", formatEvent(Event{Retry: 500 * time.Millisecond}))
+}
+
+func TestEventStream(t *testing.T) {
+	disconnected := make(chan error, 1)
+	address := startServer(t, func(w *response.Writer, r *request.Request) {
+		stream, err := NewEventStream(w, r, 20*time.Millisecond)
+		require.NoError(t, err)
+		defer stream.Close()
+		assert.Error(t, stream.Send(Event{ID: "bad\nid", Data: "x"}))
+		stream.Send(Event{ID: "43", Event: "resumed", Data: "after " + stream.LastEventID})
+		select {
+		case <-stream.Done():
+			disconnected <- stream.Err()
+		case <-time.After(5 * time.Second):
+			disconnected <- nil
+		}
+	})
+
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost\r\nLast-Event-ID: 42\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Headers, the event and a heartbeat arrive while the stream is open
+	reader := bufio.NewReader(conn)
+	received := ""
+	for !strings.Contains(received, ": heartbeat\n\n") {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err, received)
+		received += line
+	}
+	assert.True(t, strings.HasPrefix(received, "HTTP/1.1 200 OK\r\n"), received)
+	assert.Contains(t, received, "content-type: text/event-stream\r\n")
+	assert.Contains(t, received, "cache-control: no-cache\r\n")
+	assert.Contains(t, received, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, received, "id: 43\nevent: resumed\ndata: after 42\n\n")
+
+	// Test: Client disconnect is noticed through the heartbeat
+	conn.Close()
+	select {
+	case err := <-disconnected:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnect was not noticed")
+	}
+}
+
+func TestEventStreamWithoutClose(t *testing.T) {
+	address := startServer(t, func(w *response.Writer, r *request.Request) {
+		stream, err := NewEventStream(w, r, time.Millisecond)
+		require.NoError(t, err)
+		stream.Send(Event{Data: "only"})
+		time.Sleep(5 * time.Millisecond)
+	})
+
+	// Test: Heartbeat stops before the server ends the stream
+	for i := 0; i < 20; i++ {
+		conn, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err := response.ResponseFromReader(conn, "GET")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(body), "data: only\n\n"), string(body))
+		assert.Equal(t, "", strings.ReplaceAll(strings.TrimPrefix(string(body), "data: only\n\n"), ": heartbeat\n\n", ""))
+		extra, _ := io.ReadAll(conn)
+		assert.Empty(t, extra)
+		conn.Close()
+	}
+}
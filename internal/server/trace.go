@@ -0,0 +1,41 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+// Headers that carry credentials; TraceHandler never echoes their values.
+var redactedTraceHeaders = map[string]struct{}{
+	"authorization":       {},
+	"proxy-authorization": {},
+	"cookie":              {},
+}
+
+const REDACTED = "[redacted]"
+
+// TraceHandler answers a TRACE request with the request it received, as a
+// message/http body (RFC 9110 9.3.8). Credentials are redacted.
+func TraceHandler(w *response.Writer, r *request.Request) {
+	message := r.RequestLine.Method + " " + r.RequestLine.RequestTarget + " HTTP/" + r.RequestLine.HttpVersion + "\r\n"
+
+	names := []string{}
+	for name := range r.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := r.Headers[name]
+		if _, redacted := redactedTraceHeaders[name]; redacted {
+			value = REDACTED
+		}
+		message += name + ": " + value + "\r\n"
+	}
+	message += "\r\n"
+
+	w.Header().Set("Content-Type", "message/http")
+	w.WriteHeader(response.OK)
+	w.Write([]byte(message))
+}
@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"strings"
+)
+
+// DEFLATE_EXTENSION_RESPONSE is what the server answers to an accepted
+// permessage-deflate offer. Without context takeover every message is
+// compressed on its own, so no compressor state has to be kept per connection.
+const DEFLATE_EXTENSION_RESPONSE = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// deflate_tail ends every compressed message on the wire; it is removed by the
+// sender and added back by the receiver (RFC 7692 section 7.2.1).
+const deflate_tail = "\x00\x00\xff\xff"
+
+// acceptDeflateOffer reports whether one of the offers in a
+// Sec-WebSocket-Extensions header is a permessage-deflate we can serve.
+func acceptDeflateOffer(offers string) bool {
+	for _, offer := range strings.Split(offers, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		if deflateParamsAcceptable(params[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func deflateParamsAcceptable(params []string) bool {
+	seen := map[string]bool{}
+	for _, param := range params {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		name = strings.TrimSpace(name)
+		value = strings.Trim(strings.TrimSpace(value), "\"")
+		if seen[name] {
+			return false
+		}
+		seen[name] = true
+		switch name {
+		case "server_no_context_takeover", "client_no_context_takeover":
+			if value != "" {
+				return false
+			}
+		case "client_max_window_bits":
+			// we decompress with the full window, whatever the client uses
+		case "server_max_window_bits":
+			// compress/flate always uses a 32KB window (15 bits)
+			if value != "15" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func compressMessage(data []byte) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	compressor, err := flate.NewWriter(&buffer, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = compressor.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = compressor.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte(deflate_tail)), nil
+}
+
+// decompressMessage inflates a compressed message, refusing to produce more
+// than limit bytes.
+func decompressMessage(data []byte, limit int) ([]byte, error) {
+	// the tail makes the stream complete again, the empty final block after
+	// it lets the reader stop at io.EOF instead of io.ErrUnexpectedEOF
+	decompressor := flate.NewReader(io.MultiReader(
+		bytes.NewReader(data),
+		strings.NewReader(deflate_tail+"\x01\x00\x00\xff\xff"),
+	))
+	defer decompressor.Close()
+	message, err := io.ReadAll(io.LimitReader(decompressor, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(message) > limit {
+		return nil, errMessageTooBig
+	}
+	return message, nil
+}
+
+var errMessageTooBig = errors.New("message is larger than the maximum message size")
@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/headers"
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+)
+
+// ACCEPT_GUID is appended to the client's key to compute
+// Sec-WebSocket-Accept (RFC 6455 section 4.2.2).
+const ACCEPT_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const WEBSOCKET_VERSION = "13"
+
+// Upgrader turns requests into WebSocket connections from inside a handler.
+type Upgrader struct {
+	// EnableCompression accepts a permessage-deflate offer from the client
+	// (RFC 7692). Messages are then compressed in both directions, each on
+	// its own (no context takeover).
+	EnableCompression bool
+}
+
+// Upgrade validates the opening handshake in r, answers it with
+// 101 Switching Protocols and takes the connection over from the server.
+// If the handshake is invalid an error response is written to w and an error
+// is returned; the handler should just return then.
+func (u *Upgrader) Upgrade(w *response.Writer, r *request.Request) (*Conn, error) {
+	if r.RequestLine.Method != "GET" {
+		return nil, handshakeError(w, response.METHOD_NOT_ALLOWED, "websocket handshake must be a GET request", "Allow", "GET")
+	}
+	if _, ok := r.Get("Host"); !ok {
+		return nil, handshakeError(w, response.CLIENT_ERROR, "websocket handshake has no Host header")
+	}
+	if !headerHasToken(r, "Upgrade", "websocket") {
+		return nil, handshakeError(w, response.UPGRADE_REQUIRED, "websocket handshake must ask to upgrade to websocket", "Upgrade", "websocket")
+	}
+	if !headerHasToken(r, "Connection", "upgrade") {
+		return nil, handshakeError(w, response.CLIENT_ERROR, "websocket handshake must have an upgrade Connection header")
+	}
+	if version, _ := r.Get("Sec-WebSocket-Version"); version != WEBSOCKET_VERSION {
+		return nil, handshakeError(w, response.UPGRADE_REQUIRED, "websocket version must be "+WEBSOCKET_VERSION, "Sec-WebSocket-Version", WEBSOCKET_VERSION)
+	}
+	key, _ := r.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, handshakeError(w, response.CLIENT_ERROR, "Sec-WebSocket-Key must be 16 bytes in base64")
+	}
+
+	response_headers := headers.Headers{}
+	response_headers.Set("Upgrade", "websocket")
+	response_headers.Set("Connection", "Upgrade")
+	response_headers.Set("Sec-WebSocket-Accept", AcceptKey(key))
+	compress := false
+	if u.EnableCompression {
+		offers, _ := r.Get("Sec-WebSocket-Extensions")
+		if acceptDeflateOffer(offers) {
+			compress = true
+			response_headers.Set("Sec-WebSocket-Extensions", DEFLATE_EXTENSION_RESPONSE)
+		}
+	}
+
+	err := w.WriteStatusLine(response.SWITCHING_PROTOCOLS)
+	if err != nil {
+		return nil, err
+	}
+	err = w.WriteHeaders(response_headers)
+	if err != nil {
+		return nil, err
+	}
+	conn, rw, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, rw, false, compress), nil
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + ACCEPT_GUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func handshakeError(w *response.Writer, status response.StatusCode, message string, header_pair ...string) error {
+	for i := 0; i+1 < len(header_pair); i += 2 {
+		w.Header().Set(header_pair[i], header_pair[i+1])
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+	return errors.New(message)
+}
+
+// headerHasToken reports whether the comma separated header contains token,
+// compared case-insensitively.
+func headerHasToken(r *request.Request, header_name, token string) bool {
+	value, ok := r.Get(header_name)
+	if !ok {
+		return false
+	}
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,473 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) on top of
+// connections hijacked from server.Server, with optional per-message deflate
+// (RFC 7692).
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	CONTINUATION_FRAME MessageType = 0
+	TEXT_MESSAGE       MessageType = 1
+	BINARY_MESSAGE     MessageType = 2
+	CLOSE_MESSAGE      MessageType = 8
+	PING_MESSAGE       MessageType = 9
+	PONG_MESSAGE       MessageType = 10
+)
+
+// Close status codes, RFC 6455 section 7.4.1.
+const (
+	CLOSE_NORMAL           = 1000
+	CLOSE_GOING_AWAY       = 1001
+	CLOSE_PROTOCOL_ERROR   = 1002
+	CLOSE_UNSUPPORTED_DATA = 1003
+	CLOSE_NO_STATUS        = 1005
+	CLOSE_INVALID_PAYLOAD  = 1007
+	CLOSE_MESSAGE_TOO_BIG  = 1009
+)
+
+const (
+	DEFAULT_MAX_MESSAGE_SIZE = 1 << 20
+	MAX_CONTROL_PAYLOAD      = 125
+	// CLOSE_TIMEOUT bounds how long Close waits for the peer's close frame.
+	CLOSE_TIMEOUT = 5 * time.Second
+)
+
+const (
+	fin_bit     = 0x80
+	rsv1_bit    = 0x40
+	rsv2_bit    = 0x20
+	rsv3_bit    = 0x10
+	mask_bit    = 0x80
+	opcode_mask = 0x0f
+)
+
+// CloseError is returned by ReadMessage once the peer closed the connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (ce *CloseError) Error() string {
+	return "websocket closed by peer: " + strconv.Itoa(ce.Code) + " " + ce.Text
+}
+
+// Conn is a WebSocket connection. One goroutine may read while another one
+// writes; ReadMessage answers pings and close frames on its own.
+type Conn struct {
+	// MaxMessageSize limits the size of a received message, after
+	// decompression. Larger messages fail the connection with 1009.
+	MaxMessageSize int
+	// FragmentSize splits sent data messages into frames of at most this
+	// many payload bytes; 0 sends every message in a single frame.
+	FragmentSize int
+
+	conn      net.Conn
+	reader    *bufio.Reader
+	writer    *bufio.Writer
+	is_client bool // clients mask what they send, servers must not
+	compress  bool
+
+	write_mutex sync.Mutex
+	close_sent  bool
+	read_err    error
+}
+
+func newConn(conn net.Conn, rw *bufio.ReadWriter, is_client bool, compress bool) *Conn {
+	return &Conn{
+		MaxMessageSize: DEFAULT_MAX_MESSAGE_SIZE,
+		conn:           conn,
+		reader:         rw.Reader,
+		writer:         rw.Writer,
+		is_client:      is_client,
+		compress:       compress,
+	}
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  MessageType
+	payload []byte
+}
+
+// protocolError is a violation by the peer; the connection is failed with
+// code before it is returned.
+type protocolError struct {
+	code    int
+	message string
+}
+
+func (pe *protocolError) Error() string {
+	return pe.message
+}
+
+func (c *Conn) readFrame() (frame, error) {
+	header := [2]byte{}
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		fin:    header[0]&fin_bit != 0,
+		rsv1:   header[0]&rsv1_bit != 0,
+		opcode: MessageType(header[0] & opcode_mask),
+	}
+	if header[0]&(rsv2_bit|rsv3_bit) != 0 || (f.rsv1 && !c.compress) {
+		return frame{}, &protocolError{CLOSE_PROTOCOL_ERROR, "frame has reserved bits set"}
+	}
+	masked := header[1]&mask_bit != 0
+	if masked == c.is_client {
+		return frame{}, &protocolError{CLOSE_PROTOCOL_ERROR, "frames from clients must be masked and frames from servers must not"}
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := [2]byte{}
+		_, err = io.ReadFull(c.reader, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		extended := [8]byte{}
+		_, err = io.ReadFull(c.reader, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if err != nil {
+		return frame{}, err
+	}
+	if f.opcode >= CLOSE_MESSAGE {
+		if !f.fin || length > MAX_CONTROL_PAYLOAD {
+			return frame{}, &protocolError{CLOSE_PROTOCOL_ERROR, "control frames must not be fragmented or longer than 125 bytes"}
+		}
+		if f.rsv1 {
+			return frame{}, &protocolError{CLOSE_PROTOCOL_ERROR, "control frames must not be compressed"}
+		}
+	}
+	if length > uint64(c.maxMessageSize()) {
+		return frame{}, &protocolError{CLOSE_MESSAGE_TOO_BIG, errMessageTooBig.Error()}
+	}
+
+	mask_key := [4]byte{}
+	if masked {
+		_, err = io.ReadFull(c.reader, mask_key[:])
+		if err != nil {
+			return frame{}, err
+		}
+	}
+	f.payload = make([]byte, length)
+	_, err = io.ReadFull(c.reader, f.payload)
+	if err != nil {
+		return frame{}, err
+	}
+	if masked {
+		maskBytes(mask_key, f.payload)
+	}
+	return f, nil
+}
+
+func (c *Conn) maxMessageSize() int {
+	if c.MaxMessageSize <= 0 {
+		return DEFAULT_MAX_MESSAGE_SIZE
+	}
+	return c.MaxMessageSize
+}
+
+// ReadMessage returns the next text or binary message, put together from its
+// fragments. Pings are answered with pongs and pongs are dropped on the way.
+// When the peer closes, the close is answered, the connection is closed and
+// a *CloseError is returned. Any other error leaves the Conn unusable.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.read_err != nil {
+		return 0, nil, c.read_err
+	}
+	message_type, message, err := c.readMessage()
+	if err != nil {
+		var protocol_err *protocolError
+		if errors.As(err, &protocol_err) {
+			c.fail(protocol_err.code, protocol_err.message)
+		}
+		c.read_err = err
+		return 0, nil, err
+	}
+	return message_type, message, nil
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	message_type := CONTINUATION_FRAME
+	compressed := false
+	message := []byte{}
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch f.opcode {
+		case PING_MESSAGE:
+			err = c.writeControl(PONG_MESSAGE, f.payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PONG_MESSAGE:
+			continue
+		case CLOSE_MESSAGE:
+			return 0, nil, c.handleClose(f.payload)
+		case TEXT_MESSAGE, BINARY_MESSAGE:
+			if message_type != CONTINUATION_FRAME {
+				return 0, nil, &protocolError{CLOSE_PROTOCOL_ERROR, "new message started before the previous one was finished"}
+			}
+			message_type = f.opcode
+			compressed = f.rsv1
+		case CONTINUATION_FRAME:
+			if message_type == CONTINUATION_FRAME {
+				return 0, nil, &protocolError{CLOSE_PROTOCOL_ERROR, "continuation frame without a message to continue"}
+			}
+			if f.rsv1 {
+				return 0, nil, &protocolError{CLOSE_PROTOCOL_ERROR, "only the first frame of a message may be marked compressed"}
+			}
+		default:
+			return 0, nil, &protocolError{CLOSE_PROTOCOL_ERROR, "unknown opcode " + strconv.Itoa(int(f.opcode))}
+		}
+
+		if len(message)+len(f.payload) > c.maxMessageSize() {
+			return 0, nil, &protocolError{CLOSE_MESSAGE_TOO_BIG, errMessageTooBig.Error()}
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			message, err = decompressMessage(message, c.maxMessageSize())
+			if errors.Is(err, errMessageTooBig) {
+				return 0, nil, &protocolError{CLOSE_MESSAGE_TOO_BIG, err.Error()}
+			}
+			if err != nil {
+				return 0, nil, &protocolError{CLOSE_INVALID_PAYLOAD, "message could not be decompressed: " + err.Error()}
+			}
+		}
+		if message_type == TEXT_MESSAGE && !utf8.Valid(message) {
+			return 0, nil, &protocolError{CLOSE_INVALID_PAYLOAD, "text message is not valid UTF-8"}
+		}
+		return message_type, message, nil
+	}
+}
+
+// handleClose completes the close handshake the peer started (or answered)
+// and closes the connection.
+func (c *Conn) handleClose(payload []byte) error {
+	close_err := &CloseError{Code: CLOSE_NO_STATUS}
+	if len(payload) == 1 {
+		return &protocolError{CLOSE_PROTOCOL_ERROR, "close frame payload must not be a single byte"}
+	}
+	if len(payload) >= 2 {
+		close_err.Code = int(binary.BigEndian.Uint16(payload))
+		close_err.Text = string(payload[2:])
+		if !validCloseCode(close_err.Code) {
+			return &protocolError{CLOSE_PROTOCOL_ERROR, "invalid close code " + strconv.Itoa(close_err.Code)}
+		}
+		if !utf8.ValidString(close_err.Text) {
+			return &protocolError{CLOSE_INVALID_PAYLOAD, "close reason is not valid UTF-8"}
+		}
+	}
+	reply := []byte{}
+	if len(payload) >= 2 {
+		reply = payload[:2] // echo the status code
+	}
+	c.writeControl(CLOSE_MESSAGE, reply)
+	c.conn.Close()
+	return close_err
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// fail sends a close frame with code and closes the connection, without
+// waiting for the peer to answer.
+func (c *Conn) fail(code int, reason string) {
+	c.writeControl(CLOSE_MESSAGE, closePayload(code, reason))
+	c.conn.Close()
+}
+
+func closePayload(code int, reason string) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > MAX_CONTROL_PAYLOAD {
+		payload = payload[:MAX_CONTROL_PAYLOAD]
+	}
+	return payload
+}
+
+// WriteMessage sends a text, binary, ping or pong message. Data messages are
+// compressed if that was negotiated and fragmented according to FragmentSize.
+// Use WriteClose or Close to send a close frame.
+func (c *Conn) WriteMessage(message_type MessageType, data []byte) error {
+	switch message_type {
+	case PING_MESSAGE, PONG_MESSAGE:
+		return c.writeControl(message_type, data)
+	case TEXT_MESSAGE, BINARY_MESSAGE:
+	default:
+		return errors.New("cant write message of type " + strconv.Itoa(int(message_type)))
+	}
+
+	compressed := false
+	if c.compress {
+		deflated, err := compressMessage(data)
+		if err != nil {
+			return err
+		}
+		data = deflated
+		compressed = true
+	}
+
+	c.write_mutex.Lock()
+	defer c.write_mutex.Unlock()
+	if c.close_sent {
+		return errors.New("cant write message: close frame was already sent")
+	}
+	opcode := message_type
+	for {
+		payload := data
+		if c.FragmentSize > 0 && len(payload) > c.FragmentSize {
+			payload = data[:c.FragmentSize]
+		}
+		data = data[len(payload):]
+		err := c.writeFrame(frame{fin: len(data) == 0, rsv1: compressed, opcode: opcode, payload: payload})
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return c.writer.Flush()
+		}
+		opcode = CONTINUATION_FRAME
+		compressed = false
+	}
+}
+
+func (c *Conn) writeControl(opcode MessageType, payload []byte) error {
+	if len(payload) > MAX_CONTROL_PAYLOAD {
+		return errors.New("control frame payload must not be longer than 125 bytes")
+	}
+	c.write_mutex.Lock()
+	defer c.write_mutex.Unlock()
+	if c.close_sent {
+		return errors.New("cant write message: close frame was already sent")
+	}
+	if opcode == CLOSE_MESSAGE {
+		c.close_sent = true
+	}
+	err := c.writeFrame(frame{fin: true, opcode: opcode, payload: payload})
+	if err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+// writeFrame writes f to the buffered writer; write_mutex must be held.
+func (c *Conn) writeFrame(f frame) error {
+	header := make([]byte, 0, 14)
+	first := byte(f.opcode)
+	if f.fin {
+		first |= fin_bit
+	}
+	if f.rsv1 {
+		first |= rsv1_bit
+	}
+	header = append(header, first)
+
+	mask := byte(0)
+	if c.is_client {
+		mask = mask_bit
+	}
+	length := len(f.payload)
+	switch {
+	case length <= 125:
+		header = append(header, mask|byte(length))
+	case length <= 0xffff:
+		header = append(header, mask|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, mask|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	payload := f.payload
+	if c.is_client {
+		mask_key := [4]byte{}
+		_, err := rand.Read(mask_key[:])
+		if err != nil {
+			return err
+		}
+		header = append(header, mask_key[:]...)
+		payload = append([]byte{}, f.payload...) // do not mask the caller's slice
+		maskBytes(mask_key, payload)
+	}
+
+	_, err := c.writer.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = c.writer.Write(payload)
+	return err
+}
+
+func maskBytes(mask_key [4]byte, payload []byte) {
+	for i := range payload {
+		payload[i] ^= mask_key[i%4]
+	}
+}
+
+// WriteClose starts the close handshake. The peer's answer is picked up by
+// ReadMessage, which then closes the connection.
+func (c *Conn) WriteClose(code int, reason string) error {
+	return c.writeControl(CLOSE_MESSAGE, closePayload(code, reason))
+}
+
+// Close runs the whole close handshake: it sends a close frame, reads (and
+// drops) messages until the peer answers or CLOSE_TIMEOUT passes, and closes
+// the connection. Do not call it while another goroutine is in ReadMessage;
+// use WriteClose there.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.WriteClose(code, reason)
+	if err != nil {
+		c.conn.Close()
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(CLOSE_TIMEOUT))
+	for {
+		_, _, err = c.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	c.conn.Close()
+	var close_err *CloseError
+	if errors.As(err, &close_err) {
+		return nil
+	}
+	return err
+}
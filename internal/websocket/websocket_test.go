@@ -0,0 +1,210 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OmarJarbou/httpfromtcp/internal/request"
+	"github.com/OmarJarbou/httpfromtcp/internal/response"
+	"github.com/OmarJarbou/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer serves a WebSocket endpoint that sends every message back.
+func startEchoServer(t *testing.T, upgrader *Upgrader) string {
+	t.Helper()
+	s, err := server.Serve(0, func(w *response.Writer, r *request.Request) {
+		conn, err := upgrader.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		for {
+			message_type, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(message_type, message)
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return "127.0.0.1:" + strconv.Itoa(s.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+// dial sends an opening handshake with extra_headers and returns the response
+// head and, for a 101, a client side Conn.
+func dial(t *testing.T, address, extra_headers string, compress bool) (string, *Conn) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /chat HTTP/1.1\r\nHost: localhost\r\n" + extra_headers + "\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	head := ""
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		head += line
+		if line == "\r\n" {
+			break
+		}
+	}
+	if !strings.HasPrefix(head, "HTTP/1.1 101 ") {
+		return head, nil
+	}
+	return head, newConn(conn, bufio.NewReadWriter(reader, bufio.NewWriter(conn)), true, compress)
+}
+
+const handshake_headers = "Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+
+func TestAcceptKey(t *testing.T) {
+	// Test: Example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestHandshake(t *testing.T) {
+	address := startEchoServer(t, &Upgrader{})
+
+	// Test: Valid handshake
+	head, conn := dial(t, address, handshake_headers, false)
+	require.NotNil(t, conn, head)
+	assert.Contains(t, head, "upgrade: websocket\r\n")
+	assert.Contains(t, head, "sec-websocket-accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.NotContains(t, head, "sec-websocket-extensions")
+
+	// Test: Missing key
+	head, _ = dial(t, address, "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n", false)
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 400 Bad Request\r\n"), head)
+
+	// Test: Unsupported version
+	head, _ = dial(t, address, strings.Replace(handshake_headers, "Version: 13", "Version: 8", 1), false)
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 426 Upgrade Required\r\n"), head)
+	assert.Contains(t, head, "sec-websocket-version: 13\r\n")
+
+	// Test: Not an upgrade request
+	head, _ = dial(t, address, "", false)
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 426 Upgrade Required\r\n"), head)
+}
+
+func TestMessages(t *testing.T) {
+	address := startEchoServer(t, &Upgrader{})
+	_, conn := dial(t, address, handshake_headers, false)
+	require.NotNil(t, conn)
+
+	// Test: Text message is echoed
+	require.NoError(t, conn.WriteMessage(TEXT_MESSAGE, []byte("hello")))
+	message_type, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TEXT_MESSAGE, message_type)
+	assert.Equal(t, "hello", string(message))
+
+	// Test: Fragmented binary message is put back together
+	conn.FragmentSize = 3
+	payload := []byte("a message in several frames")
+	require.NoError(t, conn.WriteMessage(BINARY_MESSAGE, payload))
+	conn.FragmentSize = 0
+	message_type, message, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BINARY_MESSAGE, message_type)
+	assert.Equal(t, payload, message)
+
+	// Test: Large message uses the 64 bit length
+	payload = []byte(strings.Repeat("x", 70000))
+	require.NoError(t, conn.WriteMessage(BINARY_MESSAGE, payload))
+	_, message, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, payload, message)
+
+	// Test: Ping is answered with a pong carrying the same payload
+	require.NoError(t, conn.WriteMessage(PING_MESSAGE, []byte("are you there")))
+	f, err := conn.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, PONG_MESSAGE, f.opcode)
+	assert.Equal(t, "are you there", string(f.payload))
+
+	// Test: Close handshake
+	require.NoError(t, conn.Close(CLOSE_NORMAL, "bye"))
+	assert.Error(t, conn.WriteMessage(TEXT_MESSAGE, []byte("too late")))
+}
+
+func TestProtocolErrors(t *testing.T) {
+	address := startEchoServer(t, &Upgrader{})
+	expectClose := func(t *testing.T, conn *Conn, code int) {
+		t.Helper()
+		f, err := conn.readFrame()
+		require.NoError(t, err)
+		assert.Equal(t, CLOSE_MESSAGE, f.opcode)
+		require.GreaterOrEqual(t, len(f.payload), 2)
+		assert.Equal(t, code, int(binary.BigEndian.Uint16(f.payload)))
+		_, err = conn.reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	}
+
+	// Test: Unmasked frame from the client
+	_, conn := dial(t, address, handshake_headers, false)
+	conn.is_client = false
+	require.NoError(t, conn.WriteMessage(TEXT_MESSAGE, []byte("hello")))
+	conn.is_client = true
+	expectClose(t, conn, CLOSE_PROTOCOL_ERROR)
+
+	// Test: Text message that is not UTF-8
+	_, conn = dial(t, address, handshake_headers, false)
+	require.NoError(t, conn.WriteMessage(TEXT_MESSAGE, []byte{0xff, 0xfe}))
+	expectClose(t, conn, CLOSE_INVALID_PAYLOAD)
+
+	// Test: Continuation without a message
+	_, conn = dial(t, address, handshake_headers, false)
+	conn.write_mutex.Lock()
+	require.NoError(t, conn.writeFrame(frame{fin: true, opcode: CONTINUATION_FRAME, payload: []byte("x")}))
+	require.NoError(t, conn.writer.Flush())
+	conn.write_mutex.Unlock()
+	expectClose(t, conn, CLOSE_PROTOCOL_ERROR)
+
+	// Test: Compressed frame without negotiated compression
+	_, conn = dial(t, address, handshake_headers, false)
+	conn.write_mutex.Lock()
+	require.NoError(t, conn.writeFrame(frame{fin: true, rsv1: true, opcode: TEXT_MESSAGE, payload: []byte("x")}))
+	require.NoError(t, conn.writer.Flush())
+	conn.write_mutex.Unlock()
+	expectClose(t, conn, CLOSE_PROTOCOL_ERROR)
+}
+
+func TestCompression(t *testing.T) {
+	address := startEchoServer(t, &Upgrader{EnableCompression: true})
+
+	// Test: Offer is accepted and messages are compressed both ways
+	head, conn := dial(t, address, handshake_headers+"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n", true)
+	require.NotNil(t, conn, head)
+	assert.Contains(t, head, "sec-websocket-extensions: "+DEFLATE_EXTENSION_RESPONSE+"\r\n")
+	payload := []byte(strings.Repeat("live update ", 1000))
+	require.NoError(t, conn.WriteMessage(TEXT_MESSAGE, payload))
+	f, err := conn.readFrame()
+	require.NoError(t, err)
+	assert.True(t, f.rsv1)
+	assert.Less(t, len(f.payload), len(payload)/10)
+	message, err := decompressMessage(f.payload, DEFAULT_MAX_MESSAGE_SIZE)
+	require.NoError(t, err)
+	assert.Equal(t, payload, message)
+
+	// Test: Compressed and fragmented message
+	conn.FragmentSize = 10
+	require.NoError(t, conn.WriteMessage(TEXT_MESSAGE, payload))
+	_, message, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, payload, message)
+
+	// Test: Offer with a window we cannot honor is declined
+	head, _ = dial(t, address, handshake_headers+"Sec-WebSocket-Extensions: permessage-deflate; server_max_window_bits=10\r\n", false)
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101 Switching Protocols\r\n"), head)
+	assert.NotContains(t, head, "sec-websocket-extensions")
+}